@@ -0,0 +1,110 @@
+package mf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"reflect"
+)
+
+// Codec 缓存数据的序列化方式
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JsonCodec 使用 encoding/json 序列化, ModelFunc 默认使用
+type JsonCodec struct{}
+
+func (JsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// 压缩数据的标记字节
+const compressMarker byte = 0x00
+
+// 数据是否为 encode 压缩后的格式: 标记字节加上 gzip 头 0x1f 0x8b
+func compressed(data []byte) bool {
+	return len(data) > 2 && data[0] == compressMarker && data[1] == 0x1f && data[2] == 0x8b
+}
+
+// 序列化模型, 开启压缩且超过阈值时使用 gzip 压缩并加上标记字节
+func (c *ModelFunc) encode(model interface{}) ([]byte, error) {
+	data, err := c.codec().Marshal(c.cacheValue(model))
+	if err != nil {
+		return nil, err
+	}
+	if !c.CompressCache || len(data) < c.CompressMinBytes {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(compressMarker)
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 反序列化模型, 标记字节之后是 gzip 头的数据先解压, 其他数据直接反序列化
+// 只判断标记字节会把 msgpack 等二进制序列化中以 0x00 开头的数据当作压缩数据
+func (c *ModelFunc) decode(data []byte, model interface{}) error {
+	if compressed(data) {
+		r, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	return c.codec().Unmarshal(data, model)
+}
+
+// 缓存的模型, 复制一份并清空 CacheExclude 中的字段和带有 mf:"-" 标签的字段, 没有需要排除的字段时返回原模型
+func (c *ModelFunc) cacheValue(model interface{}) interface{} {
+	rv := reflect.ValueOf(model)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return model
+	}
+	rt := rv.Elem().Type()
+
+	var excluded []int
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.Tag.Get("mf") == "-" || c.cacheExcluded(field.Name) {
+			excluded = append(excluded, i)
+		}
+	}
+	if len(excluded) == 0 {
+		return model
+	}
+
+	cp := reflect.New(rt)
+	cp.Elem().Set(rv.Elem())
+	for _, i := range excluded {
+		if f := cp.Elem().Field(i); f.CanSet() {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	return cp.Interface()
+}
+
+func (c *ModelFunc) cacheExcluded(name string) bool {
+	for _, exclude := range c.CacheExclude {
+		if exclude == name {
+			return true
+		}
+	}
+	return false
+}
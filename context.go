@@ -0,0 +1,29 @@
+package mf
+
+import (
+	"context"
+)
+
+type noCacheKey struct{}
+
+// 返回的 ctx 传入查询方法时跳过缓存直接查询数据库, 也不写入缓存, 用于管理后台等需要最新数据的请求
+// 写入方法不受影响, 仍然会清除缓存
+func WithNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// ctx 是否设置了 WithNoCache
+func noCache(ctx context.Context) bool {
+	skip, _ := ctx.Value(noCacheKey{}).(bool)
+	return skip
+}
+
+// 查询时是否读写缓存, 在本 ModelFunc 数据库的 Transaction 中时不读写缓存
+func (c *ModelFunc) readCache(ctx context.Context) bool {
+	return c.UseCache && !noCache(ctx) && c.tx(ctx) == nil
+}
+
+// 查询时是否读写link缓存, 没有设置 RedisClient 时不缓存link
+func (c *ModelFunc) linkCache(ctx context.Context) bool {
+	return !nilRedis(c.RedisClient) && !noCache(ctx) && c.tx(ctx) == nil
+}
@@ -0,0 +1,203 @@
+package mf
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+// 钩子接口, 模型实现这些接口时直接调用, 方法签名在编译期检查, 可以使用 var _ mf.AfterUpdateByIdHook = (*Model)(nil) 断言
+// 没有实现接口时按方法名反射调用, 兼容 rdc 声明为 *redis.Client 或者带 id 参数的钩子
+
+type BeforeUpdateByIdHook interface {
+	MfBeforeUpdateById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type BeforeSaveByIdHook interface {
+	MfBeforeSaveById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type BeforeDeleteByIdHook interface {
+	MfBeforeDeleteById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type BeforeHardDeleteByIdHook interface {
+	MfBeforeHardDeleteById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type BeforeSoftDeleteByIdHook interface {
+	MfBeforeSoftDeleteById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type BeforeRestoreByIdHook interface {
+	MfBeforeRestoreById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type BeforeUpsertByIdHook interface {
+	MfBeforeUpsertById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterCreateHook interface {
+	MfAfterCreate(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterUpdateByIdHook interface {
+	MfAfterUpdateById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterSaveByIdHook interface {
+	MfAfterSaveById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterDeleteByIdHook interface {
+	MfAfterDeleteById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterHardDeleteByIdHook interface {
+	MfAfterHardDeleteById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterSoftDeleteByIdHook interface {
+	MfAfterSoftDeleteById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterRestoreByIdHook interface {
+	MfAfterRestoreById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type AfterUpsertByIdHook interface {
+	MfAfterUpsertById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+}
+
+type BeforeSoftDeleteByIdsHook interface {
+	MfBeforeSoftDeleteByIds(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient, ids []uint64) error
+}
+
+type AfterSoftDeleteByIdsHook interface {
+	MfAfterSoftDeleteByIds(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient, ids []uint64) error
+}
+
+// models 为本批次的切片, 类型与传入 CreateBatch 的切片一致, 例如 []*Model
+type AfterCreateBatchHook interface {
+	MfAfterCreateBatch(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient, models interface{}) error
+}
+
+type hookFn func(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error
+
+// 模型实现了 hookMethod 对应的钩子接口时返回该方法, 否则返回 nil
+func interfaceHook(hookMethod string, model interface{}) hookFn {
+	switch hookMethod {
+	case "MfBeforeUpdateById":
+		if h, ok := model.(BeforeUpdateByIdHook); ok {
+			return h.MfBeforeUpdateById
+		}
+	case "MfBeforeSaveById":
+		if h, ok := model.(BeforeSaveByIdHook); ok {
+			return h.MfBeforeSaveById
+		}
+	case "MfBeforeDeleteById":
+		if h, ok := model.(BeforeDeleteByIdHook); ok {
+			return h.MfBeforeDeleteById
+		}
+	case "MfBeforeHardDeleteById":
+		if h, ok := model.(BeforeHardDeleteByIdHook); ok {
+			return h.MfBeforeHardDeleteById
+		}
+	case "MfBeforeSoftDeleteById":
+		if h, ok := model.(BeforeSoftDeleteByIdHook); ok {
+			return h.MfBeforeSoftDeleteById
+		}
+	case "MfBeforeRestoreById":
+		if h, ok := model.(BeforeRestoreByIdHook); ok {
+			return h.MfBeforeRestoreById
+		}
+	case "MfBeforeUpsertById":
+		if h, ok := model.(BeforeUpsertByIdHook); ok {
+			return h.MfBeforeUpsertById
+		}
+	case "MfAfterCreate":
+		if h, ok := model.(AfterCreateHook); ok {
+			return h.MfAfterCreate
+		}
+	case "MfAfterUpdateById":
+		if h, ok := model.(AfterUpdateByIdHook); ok {
+			return h.MfAfterUpdateById
+		}
+	case "MfAfterSaveById":
+		if h, ok := model.(AfterSaveByIdHook); ok {
+			return h.MfAfterSaveById
+		}
+	case "MfAfterDeleteById":
+		if h, ok := model.(AfterDeleteByIdHook); ok {
+			return h.MfAfterDeleteById
+		}
+	case "MfAfterHardDeleteById":
+		if h, ok := model.(AfterHardDeleteByIdHook); ok {
+			return h.MfAfterHardDeleteById
+		}
+	case "MfAfterSoftDeleteById":
+		if h, ok := model.(AfterSoftDeleteByIdHook); ok {
+			return h.MfAfterSoftDeleteById
+		}
+	case "MfAfterRestoreById":
+		if h, ok := model.(AfterRestoreByIdHook); ok {
+			return h.MfAfterRestoreById
+		}
+	case "MfAfterUpsertById":
+		if h, ok := model.(AfterUpsertByIdHook); ok {
+			return h.MfAfterUpsertById
+		}
+	}
+	return nil
+}
+
+// 批量钩子实现了接口时返回该方法, 否则返回 nil
+func interfaceBatchHook(hookMethod string, model interface{}, arg interface{}) hookFn {
+	ids, isIds := arg.([]uint64)
+	switch hookMethod {
+	case "MfBeforeSoftDeleteByIds":
+		if h, ok := model.(BeforeSoftDeleteByIdsHook); ok && isIds {
+			return func(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error {
+				return h.MfBeforeSoftDeleteByIds(ctx, db, rdc, ids)
+			}
+		}
+	case "MfAfterSoftDeleteByIds":
+		if h, ok := model.(AfterSoftDeleteByIdsHook); ok && isIds {
+			return func(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error {
+				return h.MfAfterSoftDeleteByIds(ctx, db, rdc, ids)
+			}
+		}
+	case "MfAfterCreateBatch":
+		if h, ok := model.(AfterCreateBatchHook); ok {
+			return func(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error {
+				return h.MfAfterCreateBatch(ctx, db, rdc, arg)
+			}
+		}
+	}
+	return nil
+}
+
+// 调用接口钩子, 错误的包装方式与反射调用一致
+func (c *ModelFunc) callHookFn(hookMethod string, ctx context.Context, fn hookFn) error {
+	if err := fn(ctx, c.hookDB(ctx), c.RedisClient); err != nil {
+		return fmt.Errorf("mf hook %s failed: %w", hookMethod, err)
+	}
+	return nil
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	gormDBType  = reflect.TypeOf((*gorm.DB)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// 检查反射调用的钩子签名, 方法名正确但签名错误时返回错误, 不再静默跳过或者 panic
+func checkHookType(hookMethod string, t reflect.Type) error {
+	if t.NumIn() < 3 || t.NumIn() > 4 || t.In(0) != contextType || t.In(1) != gormDBType ||
+		t.NumOut() != 1 || t.Out(0) != errorType {
+		return fmt.Errorf("钩子 %s 的签名 %s 不正确, 需要为 func(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error", hookMethod, t)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package mf
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LocalCache 进程内的 LRU 缓存, 位于 redis 之前, 用于极热点的记录
+// 多实例部署时其他实例的更新无法清除本地缓存, 使用较短的 ttl 限制数据过期的时间
+type LocalCache struct {
+	mu         sync.Mutex
+	ll         *list.List
+	items      map[string]*list.Element
+	maxEntries int           // 最多缓存的条数 为0时不限制
+	maxBytes   int           // 最多缓存的字节数 为0时不限制
+	ttl        time.Duration // 本地缓存过期间隔
+	bytes      int
+}
+
+type localEntry struct {
+	key      string
+	data     []byte
+	expireAt time.Time
+}
+
+// maxEntries 和 maxBytes 都为0时默认最多缓存1000条
+func NewLocalCache(maxEntries, maxBytes int, ttl time.Duration) *LocalCache {
+	if maxEntries <= 0 && maxBytes <= 0 {
+		maxEntries = 1000
+	}
+	return &LocalCache{
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+	}
+}
+
+func (l *LocalCache) get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, exist := l.items[key]
+	if !exist {
+		return nil, false
+	}
+	entry := e.Value.(*localEntry)
+	if time.Now().After(entry.expireAt) {
+		l.removeElement(e)
+		return nil, false
+	}
+	l.ll.MoveToFront(e)
+	return entry.data, true
+}
+
+func (l *LocalCache) set(key string, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 超过字节上限的单条数据不缓存
+	if l.maxBytes > 0 && len(data) > l.maxBytes {
+		if e, exist := l.items[key]; exist {
+			l.removeElement(e)
+		}
+		return
+	}
+
+	if e, exist := l.items[key]; exist {
+		entry := e.Value.(*localEntry)
+		l.bytes += len(data) - len(entry.data)
+		entry.data = data
+		entry.expireAt = time.Now().Add(l.ttl)
+		l.ll.MoveToFront(e)
+	} else {
+		l.items[key] = l.ll.PushFront(&localEntry{key: key, data: data, expireAt: time.Now().Add(l.ttl)})
+		l.bytes += len(data)
+	}
+
+	// 淘汰最久未使用的记录
+	for (l.maxEntries > 0 && l.ll.Len() > l.maxEntries) || (l.maxBytes > 0 && l.bytes > l.maxBytes) {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *LocalCache) remove(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if e, exist := l.items[key]; exist {
+			l.removeElement(e)
+		}
+	}
+}
+
+// 清除指定前缀的所有key
+func (l *LocalCache) removePrefix(prefix string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, e := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			l.removeElement(e)
+		}
+	}
+}
+
+func (l *LocalCache) removeElement(e *list.Element) {
+	entry := l.ll.Remove(e).(*localEntry)
+	delete(l.items, entry.key)
+	l.bytes -= len(entry.data)
+}
+
+// 开启本地缓存时写入序列化后的模型
+func (c *ModelFunc) setLocal(key string, model interface{}) {
+	if c.LocalCache == nil {
+		return
+	}
+	data, err := c.codec().Marshal(c.cacheValue(model))
+	if err != nil || c.oversized(data) {
+		return
+	}
+	c.LocalCache.set(key, data)
+}
+
+// 开启本地缓存时清除对应的key
+func (c *ModelFunc) removeLocal(keys ...string) {
+	if c.LocalCache == nil {
+		return
+	}
+	c.LocalCache.remove(keys...)
+}
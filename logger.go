@@ -0,0 +1,18 @@
+package mf
+
+// Logger 调试日志接口, 记录缓存路径上的每个判断, kv 为交替的键值对, 例如 "key", "user:id:5"
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+}
+
+// NopLogger 不输出任何日志, ModelFunc 默认使用
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, kv ...interface{}) {}
+
+func (c *ModelFunc) logger() Logger {
+	if c.Logger == nil {
+		return NopLogger{}
+	}
+	return c.Logger
+}
@@ -0,0 +1,68 @@
+package mf
+
+import (
+	"time"
+)
+
+// Metrics 指标采集接口, op 为方法名, 例如 FirstById
+type Metrics interface {
+	ObserveCacheHit(op string)
+	ObserveCacheMiss(op string)
+	ObserveDBDuration(op string, d time.Duration)
+	ObserveError(op string, err error)
+}
+
+// NopMetrics 不采集任何指标, ModelFunc 默认使用
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveCacheHit(op string)                    {}
+func (NopMetrics) ObserveCacheMiss(op string)                   {}
+func (NopMetrics) ObserveDBDuration(op string, d time.Duration) {}
+func (NopMetrics) ObserveError(op string, err error)            {}
+
+func (c *ModelFunc) metrics() Metrics {
+	if c.Metrics == nil {
+		return NopMetrics{}
+	}
+	return c.Metrics
+}
+
+// 记录缓存是否命中
+func (c *ModelFunc) observeCache(op string, hit bool) {
+	if hit {
+		c.metrics().ObserveCacheHit(op)
+	} else {
+		c.metrics().ObserveCacheMiss(op)
+	}
+}
+
+// 记录数据库耗时, 使用 defer c.observeDB(op, time.Now()) 调用
+func (c *ModelFunc) observeDB(op string, start time.Time) {
+	c.metrics().ObserveDBDuration(op, time.Since(start))
+}
+
+// 记录错误, 记录不存在不计为错误, 使用 defer c.observeError(op, &err) 调用
+func (c *ModelFunc) observeError(op string, err *error) {
+	if *err != nil && !ErrIsGormNil(*err) {
+		c.metrics().ObserveError(op, *err)
+	}
+}
+
+// link 查询的结果
+const (
+	LinkHit            = "link-hit"             // link 缓存命中
+	LinkMissResolved   = "link-miss-resolved"   // link 缓存未命中, finder 查询到记录
+	LinkMissUnresolved = "link-miss-unresolved" // link 缓存未命中, finder 没有查询到记录
+)
+
+// LinkMetrics 可选的 link 指标接口, Metrics 同时实现该接口时记录每次 link 查询的结果, outcome 为 LinkHit 等常量
+type LinkMetrics interface {
+	ObserveLink(linkType string, outcome string)
+}
+
+// 记录 link 查询的结果, 只在读写link缓存时记录
+func (c *ModelFunc) observeLink(linkType string, outcome string) {
+	if m, ok := c.metrics().(LinkMetrics); ok {
+		m.ObserveLink(linkType, outcome)
+	}
+}
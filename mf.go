@@ -2,27 +2,149 @@ package mf
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/spf13/cast"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"math/rand"
+	"net/url"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 type ModelFunc struct {
 	MysqlCient  *gorm.DB              // 数据库链接
 	UseCache    bool                  // 是否使用缓存 true 自动走redis
-	RedisClient *redis.Client         // 数据库链接
+	RedisClient redis.UniversalClient // 数据库链接, 可以使用 *redis.Client、*redis.ClusterClient、*redis.Ring
 	RedisPrefix string                // redis 缓存 前缀
 	Expire      time.Duration         // redis 缓存 过期间隔
 	LinkMap     map[string]LinkFinder // redis 其他字段关联表id的查询方法
+	PrimaryKey  string                // 主键字段名 默认 id
+
+	ExpireMap map[string]time.Duration // 按表名指定 redis 缓存 过期间隔, 优先于 Expire, 多个模型共用一个 ModelFunc 时使用
+
+	ExpireJitter   time.Duration // 缓存过期间隔的随机抖动范围, 实际过期间隔为 Expire ± ExpireJitter
+	SlidingExpire  bool          // FirstById 命中缓存时重新设置过期时间, 适合经常读取很少修改的记录
+	NegativeExpire time.Duration // 空值缓存 过期间隔, 大于0时缓存不存在的记录，防止缓存穿透
+	CacheVersion   string        // 缓存版本, 拼接到缓存key中, 模型结构变更时修改版本使旧缓存失效, 设置 KeyFunc 时拼接在传给 KeyFunc 的 prefix 末尾
+
+	KeyFunc     func(prefix string, id interface{}) string         // 自定义id缓存key, 设置后替代默认的key格式, prefix 包含 CacheVersion, 返回的key需要以 prefix 开头, 否则修改版本不会使旧缓存失效
+	LinkKeyFunc func(prefix string, linkType, field string) string // 自定义link缓存key, 设置后替代默认的key格式
+
+	Metrics Metrics      // 指标采集 默认不采集
+	Tracer  trace.Tracer // 链路追踪 默认不追踪
+
+	Codec            Codec    // 缓存序列化方式 默认 json
+	CompressCache    bool     // 是否压缩缓存数据
+	CompressMinBytes int      // 序列化后超过该字节数才压缩
+	CacheExclude     []string // 不写入缓存的结构体字段名, 例如 gorm:"-" 的计算字段, 也可以在字段上加 mf:"-" 标签
+
+	LinkExpire    time.Duration            // link 缓存 过期间隔 默认7天
+	LinkExpireMap map[string]time.Duration // 按 linkType 指定 link 缓存 过期间隔, 优先于 LinkExpire, 为0时永不过期
+	LinkCacheRow  bool                     // FirstByLink 同时在link下缓存整条记录, 命中时不再读取id缓存
+
+	HashLinkKeys      bool // link缓存key中的 field 使用 sha256 十六进制代替原值, 避免key过长或者在key中保存敏感信息
+	HashLinkKeyMinLen int  // 开启 HashLinkKeys 时 field 长度超过该值才哈希, 为0时总是哈希
+
+	DryRun bool // 删除和软删方法只统计会影响的行数并通过 Logger 输出将要执行的 SQL, 不写入数据库, 不读写缓存, 不执行钩子
+
+	CacheWriteThrough bool // UpdateById、SaveById 写入数据库后把模型写入缓存而不是删除缓存, 只适合 SaveById 完整模型或者更新后模型包含整条记录的场景
+
+	UniqueColumns []string // 可以使用 FirstByUnique 查询的唯一字段, 更新时清除对应的缓存
+
+	MaxPageSize int // 分页查询每页最大条数 默认 100
+
+	SoftDeleteColumn string           // 软删字段名 默认 deleted_at
+	SoftDeleteStyle  SoftDeleteStyle  // 软删字段未删除时的取值方式 默认零值时间
+	Location         *time.Location   // 写入软删时间使用的时区 默认 UTC
+	Now              func() time.Time // 获取当前时间 默认 time.Now, 测试时可以注入固定的时间
+
+	UpdatedAtColumn string // Touch 更新的时间字段名 默认 updated_at
+
+	StrictSave bool // SaveById 前检查模型是否完整, 主键与 id 不一致或创建时间为零值时返回 ErrStrictSave, 防止零值覆盖数据库中的字段
+
+	PrimaryScope func(*gorm.DB) *gorm.DB // FirstByIdPrimary 强制读主库使用的 scope 默认兼容 gorm.io/plugin/dbresolver
+
+	VersionColumn string // 乐观锁版本号字段名, 设置后 UpdateById 以模型中的版本号为条件并将版本号加1 默认不开启
+
+	DefaultTimeout time.Duration // 每次调用的最长执行时间, 大于0时为 ctx 加上超时, 防止查询一直阻塞占用连接
+
+	LocalCache *LocalCache // 进程内缓存, 设置后 FirstById 先查询本地缓存再查询 redis 默认不开启
+
+	MaxCacheBytes int // 序列化后的缓存值超过该字节数时不写入缓存, 读取直接回源数据库 默认不限制
+
+	SelfHealCache bool // 缓存数据无法反序列化时删除该缓存并当作未命中查询数据库, 而不是返回反序列化错误
+
+	TreatNotFoundAsNil bool // FirstById、FirstByKey、FirstByIdWithMeta 记录不存在时返回 nil 而不是 gorm.ErrRecordNotFound, 模型保持调用前的值, 调用方需要检查模型的主键判断是否查询到
+
+	CacheFailOpen bool // redis 出错时只记录指标, 读取回源数据库, 写入不因清除缓存失败而返回错误
+
+	Logger Logger // 缓存路径的调试日志 默认不输出
+
+	QueryComment bool // 每条 SQL 前加上注释 /* mf:方法名 */, 用于在慢查询日志中定位调用的方法 默认不开启
+
+	OnQuery func(sql string, duration time.Duration, rows int64) // 每条 SQL 执行后调用, 用于记录慢查询, 不需要开启 gorm 的全局日志 默认不调用
+
+	MaxRetries   int           // redis 命令遇到网络错误时的最大重试次数 默认不重试
+	RetryBackoff time.Duration // 第一次重试前的等待时间, 之后每次翻倍, 不会超过 ctx 的超时时间
+
+	QualifyTable bool // 缓存key中拼接模型的表名, 多个模型共用一个 RedisPrefix 时避免key冲突 默认不开启
+
+	group    singleflight.Group // 缓存未命中时合并并发的数据库查询, id 和 link 的 key 分别加上 "id|"、"link|" 前缀, 防止相同的 key 互相共享结果
+	expireMu sync.RWMutex       // 保护 Expire 和 LinkExpire, 运行中使用 SetExpire、SetLinkExpire 修改
 }
 
+// 软删字段未删除时的取值方式
+type SoftDeleteStyle int
+
+const (
+	ZeroTimeMeansActive SoftDeleteStyle = iota // 零值时间表示未删除
+	NullMeansActive                            // NULL 表示未删除, 兼容 gorm.DeletedAt
+)
+
+// 空值缓存的占位值
+const nilCacheValue = "__nil__"
+
+// 开启 StrictSave 时 SaveById 的模型缺少主键或者创建时间, 直接 Save 会用零值覆盖数据库中的字段
+var ErrStrictSave = errors.New("mf: 模型不完整, 拒绝 Save")
+
+// 设置 VersionColumn 时 UpdateById 没有更新到记录, 记录不存在或者已经被其他请求修改
+var ErrOptimisticLock = errors.New("mf: 版本号不匹配, 记录已被修改")
+
+// UpdateByIdIf 没有更新到记录, 记录不存在、不满足附加条件或者版本号不匹配
+var ErrNoRowsAffected = errors.New("mf: 没有满足条件的记录被更新")
+
+// 开启 MaxCacheBytes 时缓存值过大没有写入缓存, 只记录到 Metrics.ObserveError, 不返回给调用方
+var ErrCacheValueTooLarge = errors.New("mf: 缓存值超过 MaxCacheBytes")
+
+// LinkMap 中不存在调用时传入的 linkType
+var ErrLinkTypeNotFound = errors.New("mf: 不存在指定的 linkType")
+
+// 模型中不存在指定的字段, 或者 link 的 field 参数为空
+var ErrMissingField = errors.New("mf: 缺少字段")
+
+// 需要id的操作传入的id为零值
+var ErrMissingId = errors.New("mf: 缺少id")
+
+// 兼容旧版本, 推荐使用 New
 func NewMf(db *gorm.DB) *ModelFunc {
-	return &ModelFunc{MysqlCient: db}
+	c, err := New(db)
+	if err != nil {
+		// db 为空时仍然返回, 兼容之后再设置 MysqlCient 的用法
+		return &ModelFunc{MysqlCient: db}
+	}
+	return c
 }
 
 type LinkFinder interface {
@@ -30,338 +152,3059 @@ type LinkFinder interface {
 	FieldValue(model interface{}) (fieldValue string)
 }
 
+// 多字段关联查询, FieldValue 需要返回 LinkFieldsValue(fields) 以便更新时清除link缓存
+type CompositeLinkFinder interface {
+	LinkFinder
+	FindFields(ctx context.Context, db *gorm.DB, fields map[string]string) (id uint64, err error)
+}
+
+// 一个 linkType 对应多个link值, 例如同一个账号可以使用邮箱、手机号、用户名查询
+// Find 接收其中任意一个值, 更新和删除时清除 FieldValues 返回的所有值对应的link缓存
+type MultiLinkFinder interface {
+	LinkFinder
+	FieldValues(model interface{}) (fieldValues []string)
+}
+
+// 统计link值对应的记录数, 与 Find 使用相同的查询条件, 用于 CountByLink 检查link值是否重复
+type CountLinkFinder interface {
+	LinkFinder
+	Count(ctx context.Context, db *gorm.DB, field string) (count int64, err error)
+}
+
+// 将多个字段拼接为link的field, 按字段名排序保证相同组合生成相同的key
+func LinkFieldsValue(fields map[string]string) string {
+	values := make(url.Values, len(fields))
+	for k, v := range fields {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
 /**
 方法列表
 	Create							// 新增一条记录
+	CreateReturningId				// 新增一条记录，并返回新记录的主键
+	CreateBatch						// 批量新增记录
+	FirstOrCreate					// 按条件查询记录，不存在时新增，返回是否新增
 	UpdateById						// 使用id更新记录,空字段不处理
-	SaveById						// 使用id更新记录
+	UpdateByIdReturning				// 使用id更新记录，并从主库重新查询整条记录到模型
+	UpdateByIdIf					// 使用id和附加条件更新记录，没有满足条件的记录时返回 ErrNoRowsAffected，用于状态切换
+	SaveById						// 使用id更新记录, 会写入全部字段包括零值, 模型需要先完整查询出来, 可以开启 StrictSave 检查
+	UpdateColumns					// 使用id和map更新记录, 零值字段也会更新
+	UpdateByIdWithCount				// 使用id更新记录，并返回影响的行数，DeleteById、SoftDeleteById 也有对应的 WithCount 方法
+	UpsertById						// 使用id新增或更新记录，记录存在时只更新 updateColumns 字段
+	Touch							// 使用id只把更新时间字段更新为当前时间并清除缓存，用于记录最近活跃时间
 	FirstById						// 使用id查询记录
-	FirstByLink 					// 使用link查询记录
+	FirstByIdWithMeta				// 使用id查询记录，并返回是否命中缓存
+	FirstByIdFresh					// 使用id查询记录，跳过缓存直接查询数据库并刷新缓存
+	Reload							// 使用模型中的主键从数据库重新查询记录到模型并刷新缓存
+	FirstByIdPrimary				// 使用id从主库查询记录并刷新缓存，用于读写分离时写入后立即读取
+	FirstByIdPreload				// 使用id查询记录并预加载 gorm 关联，不走缓存
+	FirstByIdCacheOnly				// 使用id只从缓存中查询记录，未缓存时返回 false，不查询数据库
+	FirstByIdSelect					// 使用id查询记录的部分字段，缓存与整条记录分开保存
+	FirstByIdScoped					// 使用id和 gorm scopes 查询记录，例如 SELECT ... FOR UPDATE，不走缓存
+	FirstByKey						// 使用任意类型的主键查询记录, 例如字符串 uuid, 其余 ById 方法也有对应的 ByKey 方法
+	FirstByUnique					// 使用唯一字段查询记录, 整条记录缓存在唯一字段的key下, 字段需要在 UniqueColumns 中声明
+	FirstByIds						// 使用id批量查询记录, 按 ids 顺序返回, 不存在的记录不返回
+	FirstByIdsMap					// 使用id批量查询记录，返回以id为key的 map，不存在的记录不返回
+	FirstByLink 					// 使用link查询记录，link 不存在对应的记录时返回 gorm.ErrRecordNotFound
+	FirstByLinkWithId				// 使用link查询记录，并返回link解析出的id
 	FirstByLinkSD 					// 使用link查询记录，并剔除被软删的记录
+	FirstByLinks					// 使用多个link值批量查询记录, 按 fields 顺序返回, 不存在的记录不返回
+	FirstByLinkFields				// 使用多字段link查询记录, linkType 对应的 LinkFinder 需要实现 CompositeLinkFinder
 	FirstByIdSD						// 使用id查询记录，并剔除被软删的记录
-	DeleteById						// 使用id删除记录
+	DeleteById						// 使用id删除记录，模型包含 gorm.DeletedAt 时由 gorm 软删
+	HardDeleteById					// 使用id物理删除记录，不受 gorm.DeletedAt 影响
+	DeleteByLink					// 使用link删除记录
+	DeleteByCondition				// 按条件删除记录，返回删除的行数，使用缓存时先查询匹配的记录再按条件和id删除并清除缓存
 	SoftDeleteById					// 使用id软删记录
+	SoftDeleteByIds					// 使用id批量软删记录, 只执行一条 UPDATE, 钩子按id逐条执行
+	RestoreById						// 使用id恢复被软删的记录
+	WarmById						// 使用id从数据库读取记录并强制刷新缓存
+	WarmByIds						// 使用id批量从数据库读取记录并强制刷新缓存
+	WarmLinks						// 批量查询link对应的id并写入link缓存，已存在的link跳过
+	ExpireById						// 重新设置id缓存的过期时间，不读写数据库
+	InvalidateById					// 使用id清除缓存和link缓存，不读写数据库
+	InvalidateLink					// 清除link缓存，不读写数据库
+	InvalidateKeys					// 使用一个 pipeline 批量清除指定的缓存key，在 Transaction 中调用时提交后清除
+	CacheKeys						// 返回记录对应的所有缓存key，可以收集后传给 InvalidateKeys
+	Transaction						// 在数据库事务中执行，提交后使用一个 pipeline 清除事务中收集的缓存key
+	SetCache						// 使用配置的序列化方式和 RedisPrefix 缓存任意值，例如原生 SQL 的查询结果
+	GetCache						// 读取 SetCache 写入的值，不存在时返回 false
+	FlushPrefix						// 使用 SCAN 分批删除 RedisPrefix 开头的所有缓存
+	Validate						// 检查配置是否正确
+	SetExpire						// 运行中修改缓存过期间隔，直接修改 Expire 字段不是并发安全的
+	SetLinkExpire					// 运行中修改 link 缓存过期间隔
+	LinkTypes						// 返回已注册的 linkType
+	ValidateLinks					// 检查 LinkMap 中的 LinkFinder 是否为空，New 创建时会调用
+	Ping							// 检查数据库和 redis 连接，用于健康检查
+	Count							// 按条件统计记录数，不走缓存
+	CountByLink						// 统计link值对应的记录数，用于检查link值是否重复，LinkFinder 需要实现 CountLinkFinder
+	Paginate						// 按条件分页查询记录，返回总数，不走缓存
+	FindByCondition					// 按条件查询多条记录，不走缓存，总是查询数据库
+	Exists							// 使用id判断记录是否存在
 参数说明
 	model 参数必须是指针类型的模型
 钩子
-	MfAfterUpdateById(ctx context.Context, db *gorm.Db, rdc *redis.Client)			// 在 UpdateById 方法执行之后 执行
-	MfAfterSaveById(ctx context.Context, db *gorm.Db, rdc *redis.Client)			// 在 SaveById 方法执行之后 执行
-	MfAfterDeleteById(ctx context.Context, db *gorm.Db, rdc *redis.Client)			// 在 DeleteById 方法执行之后 执行
-	MfAfterSoftDeleteById(ctx context.Context, db *gorm.Db, rdc *redis.Client)		// 在 SoftDeleteById 方法执行之后 执行
+	MfBeforeUpdateById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 UpdateById 方法执行之前 执行, 返回错误时不执行更新
+	MfBeforeSaveById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 SaveById 方法执行之前 执行, 返回错误时不执行更新
+	MfBeforeDeleteById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 DeleteById 方法执行之前 执行, 返回错误时不执行删除
+	MfBeforeHardDeleteById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)		// 在 HardDeleteById 方法执行之前 执行, 返回错误时不执行删除
+	MfBeforeSoftDeleteById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)		// 在 SoftDeleteById 方法执行之前 执行, 返回错误时不执行软删
+	MfBeforeRestoreById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)		// 在 RestoreById 方法执行之前 执行, 返回错误时不执行恢复
+	MfBeforeUpsertById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 UpsertById 方法执行之前 执行, 返回错误时不执行写入
+	MfAfterCreate(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)				// 在 Create 方法执行之后 执行, FirstOrCreate 新增时执行, CreateBatch 不执行
+	MfAfterUpdateById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 UpdateById 方法执行之后 执行
+	MfAfterSaveById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 SaveById 方法执行之后 执行
+	MfAfterDeleteById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 DeleteById 方法执行之后 执行
+	MfAfterHardDeleteById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)		// 在 HardDeleteById 方法执行之后 执行
+	MfAfterSoftDeleteById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)		// 在 SoftDeleteById 方法执行之后 执行
+	MfAfterRestoreById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 RestoreById 方法执行之后 执行
+	MfAfterUpsertById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient)			// 在 UpsertById 方法执行之后 执行
+	MfBeforeSoftDeleteByIds(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient, ids []uint64)		// 在 SoftDeleteByIds 方法执行之前 执行一次, 在逐条的 MfBeforeSoftDeleteById 之前
+	MfAfterSoftDeleteByIds(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient, ids []uint64)		// 在 SoftDeleteByIds 方法执行之后 执行一次, 在逐条的 MfAfterSoftDeleteById 之后
+	MfAfterCreateBatch(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient, models []*Model)		// 在 CreateBatch 方法执行之后 每个批次执行一次, models 为该批次的记录, 声明在切片的元素类型上, models 的类型需要与传入的切片一致
+	MfAfter 钩子只在写入数据库成功后执行，写入失败时直接返回写入的错误
+	钩子返回的错误会包装为 "mf hook 钩子名 failed: 原始错误"，可以使用 errors.Is、errors.As 判断原始错误
+	模型可以实现 AfterUpdateByIdHook 等钩子接口，编译期检查方法签名，没有实现接口时按方法名反射调用
+	批量钩子对应 BeforeSoftDeleteByIdsHook、AfterSoftDeleteByIdsHook、AfterCreateBatchHook 接口，AfterCreateBatchHook 的 models 为 interface{}，需要断言为切片类型，声明为具体切片类型的 MfAfterCreateBatch 只能反射调用
+	钩子的 rdc 参数也可以声明为 *redis.Client, 需要与 RedisClient 的实际类型一致，这种钩子和带 id 参数的钩子只能反射调用
+	反射调用时方法名正确但签名不正确会返回错误，不会跳过
+	钩子可以声明第四个参数 id uint64, 用于接收本次操作的id, 例如 MfAfterUpdateById(ctx context.Context, db *gorm.Db, rdc redis.UniversalClient, id uint64)
 逻辑说明
+	错误可以使用 errors.Is 判断类型: ErrLinkTypeNotFound、ErrMissingField、ErrMissingId、ErrOptimisticLock、ErrStrictSave、ErrNoRowsAffected, 记录不存在时为 gorm.ErrRecordNotFound
 	使用缓存时，更新数据，会清理调对应的缓存。查询时才会创建对应的缓存
+	LinkFinder 实现 MultiLinkFinder 时，更新和删除记录会清除 FieldValue 和 FieldValues 返回的所有link值的缓存
+	FirstByIdSD 的缓存key为id缓存key加上 ":sd"，与 FirstById 的缓存分开保存，更新和删除记录时一起清除
+	设置 NegativeExpire 时，不存在的记录使用 NegativeExpire 作为空值缓存的过期间隔，Create、CreateBatch 新增记录后删除该id的空值缓存
+	设置 LinkMap 或 UniqueColumns 时，UpdateById、SaveById、UpdateColumns、UpsertById 更新前先查询原记录，同时清除原link值和原唯一字段值的缓存
+	ctx 使用 WithNoCache 包装时，查询方法跳过 redis 和本地缓存直接查询数据库，也不写入缓存，写入方法仍然清除缓存
+	开启 DryRun 时，DeleteById、HardDeleteById、DeleteByLink、SoftDeleteById、SoftDeleteByIds、DeleteByCondition 只统计匹配的行数，WithCount 方法和 DeleteByCondition 返回该行数，将要执行的 SQL 通过 Logger 输出，不读写缓存，DeleteByLink 也不写入link缓存
+	开启 CacheWriteThrough 时，UpdateById、SaveById 写入数据库后把模型写入id缓存，UpdateById 只更新非零字段，模型不是完整记录时会把不完整的数据写入缓存，这种情况需要使用 SaveById 或者先查询完整记录再更新
+	设置 LocalCache 时，FirstById 依次查询本地缓存、redis、数据库，更新数据时同时清除本实例的本地缓存，其他实例的本地缓存在 ttl 后过期
+	CacheExclude 中的字段和带有 mf:"-" 标签的字段写入缓存时为零值，命中缓存时需要调用方重新计算
+	开启 HashLinkKeys 时，link缓存的key为 RedisPrefix + linkType + ":" + field 的 sha256 十六进制，LinkKeyFunc 收到的也是哈希后的 field
+	开启 QualifyTable 时，id缓存和唯一字段缓存的key为 RedisPrefix + 表名 + ":" + 原有格式，link缓存的key不变
+	设置 VersionColumn 时，UpdateById 只更新版本号与模型一致的记录，没有更新到记录时返回 ErrOptimisticLock，UpdateByIdIf 返回 ErrNoRowsAffected
+	Metrics 同时实现 LinkMetrics 时，读写link缓存的查询会记录 LinkHit、LinkMissResolved、LinkMissUnresolved，用于统计link缓存命中率
+	设置 MaxCacheBytes 时，序列化后超过该大小的记录不写入缓存并清除旧缓存，FirstByIdSelect、WarmByIds、SetCache 和本地缓存同样生效，记录到 Metrics.ObserveError("MaxCacheBytes", ErrCacheValueTooLarge)，该记录每次查询都回源数据库
+	开启 SelfHealCache 时，缓存数据损坏无法反序列化时删除该缓存，重新查询数据库并回填缓存，错误记录到 Metrics.ObserveError
+	开启 QueryComment 时，SQL 以内容为 mf:FirstById 形式的块注释开头，注释中为发起查询的方法名，LinkFinder 中的查询使用 FirstByLink 等外层方法名，数据库驱动自定义了 INSERT 子句的构建方式时(例如 sqlite) INSERT 语句不带注释
+	设置 OnQuery 时，只替换本次调用的 gorm 会话的 logger，传入 LinkFinder 的 db 也会调用 OnQuery
+	Transaction 的 fn 需要使用传入的 ctx 调用方法，使用同一个 MysqlCient 的其他 ModelFunc 也会加入该事务，MysqlCient 不同的 ModelFunc 不加入，钩子收到的 db 为事务的链接
+	Transaction 中查询不读写缓存，写入方法需要清除的缓存在提交后统一清除，回滚时不清除，嵌套调用 Transaction 时加入外层事务
+	开启 TreatNotFoundAsNil 时，FirstById、FirstByKey、FirstByIdWithMeta 记录不存在返回 nil 且不修改模型，调用前模型主键为零值时可以用主键是否为零判断是否查询到，Repo 的 FirstById 返回 nil, nil，其他查询方法仍然返回 gorm.ErrRecordNotFound
+	开启 CacheFailOpen 时，redis 出错只记录到 Metrics.ObserveError，查询回源数据库，写入数据库成功后清除缓存失败也不返回错误
 */
 
-func (c *ModelFunc) Create(ctx context.Context, model interface{}) error {
-	return c.MysqlCient.WithContext(ctx).Create(model).Error
-}
+func (c *ModelFunc) Create(ctx context.Context, model interface{}) (err error) {
+	defer c.observeError("Create", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "Create")
 
-func (c *ModelFunc) UpdateById(ctx context.Context, model interface{}, id uint64) (err error) {
-	if c.UseCache {
-		err = c.updateByIdR(ctx, model, id)
-	} else {
-		err = c.updateByIdM(ctx, model, id)
+	if err = c.Validate(); err != nil {
+		return err
 	}
 
-	return c.hook("MfAfterUpdateById", ctx, model)
-}
-
-func (c *ModelFunc) SaveById(ctx context.Context, model interface{}, id uint64) (err error) {
-	if c.UseCache {
-		err = c.saveByIdR(ctx, model, id)
-	} else {
-		err = c.saveByIdM(ctx, model, id)
+	start := time.Now()
+	err = c.db(ctx).Create(model).Error
+	c.observeDB("Create", start)
+	if err != nil {
+		return err
 	}
 
-	return c.hook("MfAfterSaveById", ctx, model)
+	id, _ := c.modelId(ctx, model)
+	if err = c.cacheErr("Create", c.delNilCache(ctx, model, id)); err != nil {
+		return err
+	}
+	return c.hook("MfAfterCreate", ctx, model, id)
 }
 
-func (c *ModelFunc) FirstById(ctx context.Context, model interface{}, id uint64) (err error) {
-	if c.UseCache {
-		err = c.firstByIdR(ctx, model, id)
-	} else {
-		err = c.firstByIdM(ctx, model, id)
+// 新增一条记录, 并返回数据库生成的主键
+func (c *ModelFunc) CreateReturningId(ctx context.Context, model interface{}) (uint64, error) {
+	if err := c.Create(ctx, model); err != nil {
+		return 0, err
 	}
-	return
+	return c.modelId(ctx, model)
 }
 
-func (c *ModelFunc) FirstByLink(ctx context.Context, linkType string, model interface{}, field string) (err error) {
-	finder, exist := c.LinkMap[linkType]
-	if !exist {
-		return errors.New("不存在指定的 linkType")
-	}
-	id, _ := c.getLink(ctx, linkType, field)
-	if cast.ToUint64(id) == 0 {
-		idInt, err := finder.Find(ctx, c.MysqlCient, field)
-		if err != nil {
-			return err
-		}
+func (c *ModelFunc) CreateBatch(ctx context.Context, models interface{}, batchSize int) (err error) {
+	defer c.observeError("CreateBatch", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "CreateBatch")
 
-		if idInt > 0 {
-			if err = c.createLink(ctx, idInt, linkType, field); err != nil {
-				return err
-			}
+	if err = c.Validate(); err != nil {
+		return err
+	}
 
-			id = cast.ToString(idInt)
-		}
+	list := reflect.Indirect(reflect.ValueOf(models))
+	if list.Kind() != reflect.Slice {
+		return errors.New("CreateBatch 参数 models 必须是切片或切片指针")
 	}
-	if cast.ToUint64(id) > 0 {
-		err = c.FirstById(ctx, model, cast.ToUint64(id))
+	if list.Len() == 0 {
+		return nil
+	}
+	if batchSize <= 0 {
+		batchSize = list.Len()
 	}
-	return
-}
 
-func (c *ModelFunc) FirstByIdSD(ctx context.Context, model interface{}, id uint64) (err error) {
-	if c.UseCache {
-		err = c.firstByIdFilterSoftDelR(ctx, model, id)
-	} else {
-		err = c.firstByIdFilterSoftDelM(ctx, model, id)
+	// 批量写入, 没有id 不创建缓存
+	start := time.Now()
+	err = c.db(ctx).CreateInBatches(models, batchSize).Error
+	c.observeDB("CreateBatch", start)
+	if err != nil {
+		return err
 	}
-	return
-}
 
-func (c *ModelFunc) FirstByLinkSD(ctx context.Context, linkType string, model interface{}, field string) (err error) {
-	finder, exist := c.LinkMap[linkType]
-	if !exist {
-		return errors.New("不存在指定的 linkType")
+	// 使用一个 pipeline 删除新记录的空值缓存
+	if c.UseCache && c.NegativeExpire > 0 {
+		keys := make([]string, 0, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			row := modelPtr(list.Index(i))
+			if id, _ := c.modelId(ctx, row); id > 0 {
+				keys = append(keys, c.cacheKey(row, id))
+			}
+		}
+		if err = c.cacheErr("CreateBatch", c.delKeys(ctx, keys...)); err != nil {
+			return err
+		}
 	}
-	id, _ := c.getLink(ctx, linkType, field)
-	if cast.ToUint64(id) == 0 {
-		idInt, err := finder.Find(ctx, c.MysqlCient, field)
-		if err != nil {
+
+	// 批量钩子声明在切片元素类型上, 每个批次执行一次, 收到该批次的切片
+	hookModel := modelPtr(newModel(list.Type().Elem()))
+	for start := 0; start < list.Len(); start += batchSize {
+		end := start + batchSize
+		if end > list.Len() {
+			end = list.Len()
+		}
+		if err = c.batchHook("MfAfterCreateBatch", ctx, hookModel, list.Slice(start, end).Interface()); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		if idInt > 0 {
-			if err = c.createLink(ctx, idInt, linkType, field); err != nil {
-				return err
-			}
+func (c *ModelFunc) FirstOrCreate(ctx context.Context, model interface{}, conds ...interface{}) (created bool, err error) {
+	defer c.observeError("FirstOrCreate", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "FirstOrCreate")
 
-			id = cast.ToString(idInt)
-		}
+	if err = c.Validate(); err != nil {
+		return false, err
 	}
-	if cast.ToUint64(id) > 0 {
-		err = c.FirstById(ctx, model, cast.ToUint64(id))
+
+	start := time.Now()
+	tx := c.db(ctx)
+	if len(conds) > 0 {
+		tx = tx.Where(conds[0], conds[1:]...)
+	}
+	tx = tx.FirstOrCreate(model)
+	c.observeDB("FirstOrCreate", start)
+	if tx.Error != nil {
+		return false, tx.Error
 	}
-	return
-}
 
-func (c *ModelFunc) DeleteById(ctx context.Context, model interface{}, id uint64) (err error) {
-	if c.UseCache {
-		err = c.deleteByIdR(ctx, model, id)
-	} else {
-		err = c.deleteByIdM(ctx, model, id)
+	// 查询到记录时 RowsAffected 为0
+	if tx.RowsAffected == 0 {
+		return false, nil
 	}
-	return c.hook("MfAfterDeleteById", ctx, model)
-}
 
-func (c *ModelFunc) SoftDeleteById(ctx context.Context, model interface{}, id uint64) (err error) {
+	id, err := c.modelId(ctx, model)
+	if err != nil {
+		return true, err
+	}
 	if c.UseCache {
-		err = c.softDeleteByIdR(ctx, model, id)
-	} else {
-		err = c.softDeleteByIdM(ctx, model, id)
+		if err = c.cacheErr("FirstOrCreate", c.updateCache(ctx, model, id)); err != nil {
+			return true, err
+		}
 	}
-	return c.hook("MfAfterSoftDeleteById", ctx, model)
+	return true, c.hook("MfAfterCreate", ctx, model, id)
+}
+
+func (c *ModelFunc) UpdateById(ctx context.Context, model interface{}, id uint64) error {
+	return c.UpdateByKey(ctx, model, id)
 }
 
-func (c *ModelFunc) cacheKey(id uint64) string {
-	return c.RedisPrefix + "id:" + cast.ToString(id)
+func (c *ModelFunc) UpdateByKey(ctx context.Context, model interface{}, id interface{}) error {
+	_, err := c.updateByKey(ctx, model, id, false)
+	return err
 }
 
-func (c *ModelFunc) deleteCache(ctx context.Context, id uint64) error {
-	return c.RedisClient.Del(ctx, c.cacheKey(id)).Err()
+// 返回更新影响的行数, 可以结合条件判断记录是否被其他请求修改
+func (c *ModelFunc) UpdateByIdWithCount(ctx context.Context, model interface{}, id uint64) (int64, error) {
+	return c.updateByKey(ctx, model, id, false)
 }
 
-func (c *ModelFunc) updateCache(ctx context.Context, model interface{}, id uint64) error {
-	marshalData, _ := json.Marshal(model)
+// 使用id更新记录, 然后从主库重新查询整条记录到模型并刷新缓存, 用于返回更新后的完整记录
+func (c *ModelFunc) UpdateByIdReturning(ctx context.Context, model interface{}, id uint64) error {
+	if _, err := c.updateByKey(ctx, model, id, false); err != nil {
+		return err
+	}
+	return c.FirstByIdPrimary(ctx, model, id)
+}
 
-	return c.RedisClient.Set(ctx, c.cacheKey(id), string(marshalData), c.Expire).Err()
+// 条件更新, conds 与 gorm 的 Where 参数相同, 和 id 条件一起作为 WHERE, 例如 "status = ?", 1
+// 没有更新到记录时返回 ErrNoRowsAffected, 不更新缓存也不调用 MfAfterUpdateById, 用于状态机的状态切换
+// conds 为空时记录不存在同样返回 ErrNoRowsAffected, 设置 VersionColumn 时版本号不匹配也返回 ErrNoRowsAffected
+// MySQL 返回的是实际修改的行数而不是匹配的行数, 写入的值与原值完全相同时也会返回 ErrNoRowsAffected
+func (c *ModelFunc) UpdateByIdIf(ctx context.Context, model interface{}, id uint64, conds ...interface{}) error {
+	_, err := c.updateByKey(ctx, model, id, true, conds...)
+	return err
 }
 
-func (c *ModelFunc) getCache(ctx context.Context, model interface{}, id uint64) error {
-	res, err := c.RedisClient.Get(ctx, c.cacheKey(id)).Result()
+// requireRows 为 true 时没有更新到记录返回 ErrNoRowsAffected
+// conds 为 UpdateByIdIf 的附加条件, 只传给本次更新, 不会传递给钩子中的其他调用
+func (c *ModelFunc) updateByKey(ctx context.Context, model interface{}, id interface{}, requireRows bool, conds ...interface{}) (rows int64, err error) {
+	defer c.observeError("UpdateById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "UpdateById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return 0, err
+	}
+
+	if err = c.hook("MfBeforeUpdateById", ctx, model, id); err != nil {
+		return 0, err
+	}
+
+	if c.UseCache {
+		rows, err = c.updateByIdR(ctx, model, id, requireRows, conds...)
+	} else {
+		rows, err = c.updateByIdM(ctx, model, id, requireRows, conds...)
+	}
 	if err != nil {
-		return err
+		return rows, err
 	}
 
-	return json.Unmarshal([]byte(res), model)
+	return rows, c.hook("MfAfterUpdateById", ctx, model, id)
 }
 
-func (c *ModelFunc) updateByIdM(ctx context.Context, model interface{}, id uint64) error {
-	return c.MysqlCient.WithContext(ctx).Where("id = ?", id).Updates(model).Error
+func (c *ModelFunc) SaveById(ctx context.Context, model interface{}, id uint64) error {
+	return c.SaveByKey(ctx, model, id)
 }
 
-func (c *ModelFunc) updateByIdR(ctx context.Context, model interface{}, id uint64) error {
-	// 更新
-	if err := c.updateByIdM(ctx, model, id); err != nil {
+func (c *ModelFunc) SaveByKey(ctx context.Context, model interface{}, id interface{}) (err error) {
+	defer c.observeError("SaveById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "SaveById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
 		return err
 	}
 
-	// 清除缓存
-	if err := c.deleteCache(ctx, id); err != nil {
+	if c.StrictSave {
+		if err = c.checkSave(ctx, model, id); err != nil {
+			return err
+		}
+	}
+
+	if err = c.hook("MfBeforeSaveById", ctx, model, id); err != nil {
 		return err
 	}
 
-	// 清除link缓存
-	for linkType, linkFunc := range c.LinkMap {
-		c.delLink(ctx, linkType, linkFunc.FieldValue(model))
+	if c.UseCache {
+		err = c.saveByIdR(ctx, model, id)
+	} else {
+		err = c.saveByIdM(ctx, model, id)
+	}
+	if err != nil {
+		return err
 	}
 
-	// 返回
-	return nil
+	return c.hook("MfAfterSaveById", ctx, model, id)
 }
 
-func (c *ModelFunc) saveByIdM(ctx context.Context, model interface{}, id uint64) error {
-	return c.MysqlCient.WithContext(ctx).Where("id = ?", id).Save(model).Error
-}
+func (c *ModelFunc) UpdateColumns(ctx context.Context, model interface{}, id uint64, fields map[string]interface{}) (err error) {
+	defer c.observeError("UpdateColumns", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "UpdateColumns", idAttr(id))
+	defer func() { endSpan(span, err) }()
 
-func (c *ModelFunc) saveByIdR(ctx context.Context, model interface{}, id uint64) error {
-	// 更新
-	if err := c.saveByIdM(ctx, model, id); err != nil {
+	if err = c.Validate(); err != nil {
 		return err
 	}
 
-	// 清除缓存
-	if err := c.deleteCache(ctx, id); err != nil {
+	if c.UseCache {
+		err = c.updateColumnsR(ctx, model, id, fields)
+	} else {
+		err = c.updateColumnsM(ctx, model, id, fields)
+	}
+	return
+}
+
+// 记录不存在时新增, 存在时更新 updateColumns 字段, updateColumns 为空时更新全部字段, 在一条语句中完成
+func (c *ModelFunc) UpsertById(ctx context.Context, model interface{}, id uint64, updateColumns []string) (err error) {
+	defer c.observeError("UpsertById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "UpsertById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
 		return err
 	}
 
-	// 清除link缓存
-	for linkType, linkFunc := range c.LinkMap {
-		c.delLink(ctx, linkType, linkFunc.FieldValue(model))
+	if err = c.hook("MfBeforeUpsertById", ctx, model, id); err != nil {
+		return err
 	}
 
-	// 返回
-	return nil
+	if c.UseCache {
+		err = c.upsertByIdR(ctx, model, id, updateColumns)
+	} else {
+		err = c.upsertByIdM(ctx, model, id, updateColumns)
+	}
+	if err != nil {
+		return err
+	}
+	return c.hook("MfAfterUpsertById", ctx, model, id)
 }
 
-func (c *ModelFunc) firstByIdM(ctx context.Context, model interface{}, id uint64) error {
-	return c.MysqlCient.WithContext(ctx).Where("id = ?", id).First(model).Error
+func (c *ModelFunc) FirstById(ctx context.Context, model interface{}, id uint64) (err error) {
+	_, err = c.firstByKey(ctx, model, id)
+	return c.notFound(err)
 }
 
-func (c *ModelFunc) firstByIdR(ctx context.Context, model interface{}, id uint64) error {
-	if err := c.getCache(ctx, model, id); err != nil && !ErrIsRedisNil(err) {
-		return err
-	} else if ErrIsRedisNil(err) {
-		if err = c.firstByIdM(ctx, model, id); err != nil {
-			return err
-		}
+func (c *ModelFunc) FirstByIdWithMeta(ctx context.Context, model interface{}, id uint64) (hit bool, err error) {
+	hit, err = c.firstByKey(ctx, model, id)
+	return hit, c.notFound(err)
+}
 
-		if err = c.updateCache(ctx, model, id); err != nil {
-			return err
-		}
+// 开启 TreatNotFoundAsNil 时记录不存在返回 nil, 模型保持调用前的值
+func (c *ModelFunc) notFound(err error) error {
+	if c.TreatNotFoundAsNil && ErrIsGormNil(err) {
+		return nil
 	}
+	return err
+}
 
+func (c *ModelFunc) FirstByIdFresh(ctx context.Context, model interface{}, id uint64) (err error) {
+	defer c.observeError("FirstByIdFresh", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIdFresh", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+	if err = c.firstByIdM(ctx, model, id); err != nil {
+		return err
+	}
+	if c.readCache(ctx) {
+		return c.cacheErr("FirstByIdFresh", c.updateCache(ctx, model, id))
+	}
 	return nil
 }
 
-func (c *ModelFunc) firstByIdFilterSoftDelM(ctx context.Context, model interface{}, id uint64) error {
-	return c.MysqlCient.WithContext(ctx).Where("id = ? AND deleted_at = ?", id, time.Time{}).First(model).Error
-}
+// 使用模型中的主键从数据库重新查询记录到模型并刷新缓存, 用于已经持有模型时获取其他地方修改后的最新数据
+func (c *ModelFunc) Reload(ctx context.Context, model interface{}) (err error) {
+	defer c.observeError("Reload", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "Reload")
+	defer func() { endSpan(span, err) }()
 
-func (c *ModelFunc) firstByIdFilterSoftDelR(ctx context.Context, model interface{}, id uint64) error {
-	if err := c.getCache(ctx, model, id); err != nil && !ErrIsRedisNil(err) {
+	if err = c.Validate(); err != nil {
 		return err
-	} else if ErrIsRedisNil(err) {
-		if err = c.firstByIdFilterSoftDelM(ctx, model, id); err != nil {
-			return err
-		}
+	}
 
-		if err = c.updateCache(ctx, model, id); err != nil {
-			return err
-		}
+	field, err := c.lookUpField(model, c.primaryKey())
+	if err != nil {
+		return err
 	}
+	id, isZero := field.ValueOf(ctx, reflect.Indirect(reflect.ValueOf(model)))
+	if isZero {
+		return fmt.Errorf("%w: Reload 的模型主键为零值", ErrMissingId)
+	}
+	span.SetAttributes(idAttr(id))
 
+	if err = c.firstByIdM(ctx, model, id); err != nil {
+		return err
+	}
+	if c.readCache(ctx) {
+		return c.cacheErr("Reload", c.updateCache(ctx, model, id))
+	}
 	return nil
 }
 
-func (c *ModelFunc) deleteByIdM(ctx context.Context, model interface{}, id uint64) error {
-	return c.MysqlCient.WithContext(ctx).Where("id = ?", id).Delete(model).Error
+// 使用id从主库查询记录并刷新缓存, 用于写入后立即读取, 避免读到从库或缓存中的旧数据
+func (c *ModelFunc) FirstByIdPrimary(ctx context.Context, model interface{}, id uint64) (err error) {
+	defer c.observeError("FirstByIdPrimary", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIdPrimary", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+	if err = c.firstByIdScopedM(ctx, model, id, c.primaryScope()); err != nil {
+		return err
+	}
+	if c.readCache(ctx) {
+		return c.cacheErr("FirstByIdPrimary", c.updateCache(ctx, model, id))
+	}
+	return nil
 }
 
-func (c *ModelFunc) deleteByIdR(ctx context.Context, model interface{}, id uint64) error {
-	if err := c.deleteByIdM(ctx, model, id); err != nil {
+// 使用id和 gorm scopes 查询记录, 例如 Unscoped、Select、加锁, 结果受 scopes 影响所以不读写缓存
+func (c *ModelFunc) FirstByIdScoped(ctx context.Context, model interface{}, id uint64, scopes ...func(*gorm.DB) *gorm.DB) (err error) {
+	defer c.observeError("FirstByIdScoped", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIdScoped", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
 		return err
 	}
+	return c.firstByIdScopedM(ctx, model, id, scopes...)
+}
+
+// 使用id查询记录并预加载关联, preloads 为 gorm Preload 的关联名, 关联数据不写入缓存所以不走缓存
+func (c *ModelFunc) FirstByIdPreload(ctx context.Context, model interface{}, id uint64, preloads ...string) (err error) {
+	defer c.observeError("FirstByIdPreload", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIdPreload", idAttr(id))
+	defer func() { endSpan(span, err) }()
 
-	if err := c.deleteCache(ctx, id); err != nil {
+	if err = c.Validate(); err != nil {
 		return err
 	}
+	return c.firstByIdPreloadM(ctx, model, id, preloads...)
+}
 
-	// 清除link缓存
-	for linkType, linkFunc := range c.LinkMap {
-		c.delLink(ctx, linkType, linkFunc.FieldValue(model))
+// 只从缓存中读取记录, 未缓存时返回 found 为 false 且不查询数据库, 命中空值缓存时返回 gorm.ErrRecordNotFound
+func (c *ModelFunc) FirstByIdCacheOnly(ctx context.Context, model interface{}, id uint64) (found bool, err error) {
+	defer c.observeError("FirstByIdCacheOnly", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIdCacheOnly", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return false, err
 	}
 
-	return nil
-}
+	if !c.UseCache {
+		return false, errors.New("FirstByIdCacheOnly 需要开启 UseCache")
+	}
+	key := c.cacheKey(model, id)
+	if c.LocalCache != nil {
+		if data, exist := c.LocalCache.get(key); exist {
+			c.observeCache("FirstByIdCacheOnly", true)
+			return true, c.codec().Unmarshal(data, model)
+		}
+	}
 
-func (c *ModelFunc) softDeleteByIdM(ctx context.Context, model interface{}, id uint64) error {
-	return c.MysqlCient.WithContext(ctx).Model(model).Where("id = ?", id).Updates(map[string]interface{}{"deleted_at": GetNowTime()}).Error
+	err = c.getCache(ctx, model, id)
+	if ErrIsRedisNil(err) {
+		c.observeCache("FirstByIdCacheOnly", false)
+		return false, nil
+	}
+	c.observeCache("FirstByIdCacheOnly", err == nil || ErrIsGormNil(err))
+	if err != nil {
+		return false, err
+	}
+	c.setLocal(key, model)
+	return true, nil
 }
 
-func (c *ModelFunc) softDeleteByIdR(ctx context.Context, model interface{}, id uint64) error {
-	if err := c.softDeleteByIdM(ctx, model, id); err != nil {
-		return err
+// 使用id查询记录的部分字段, 缓存与整条记录的缓存分开保存, 互不覆盖
+func (c *ModelFunc) FirstByIdSelect(ctx context.Context, model interface{}, id uint64, columns []string) (err error) {
+	if len(columns) == 0 {
+		return c.FirstById(ctx, model, id)
 	}
 
-	if err := c.deleteCache(ctx, id); err != nil {
+	defer c.observeError("FirstByIdSelect", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIdSelect", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
 		return err
 	}
 
-	// 清除link缓存
-	for linkType, linkFunc := range c.LinkMap {
-		c.delLink(ctx, linkType, linkFunc.FieldValue(model))
+	if c.readCache(ctx) {
+		return c.firstByIdSelectR(ctx, model, id, columns)
 	}
-
-	return nil
+	return c.firstByIdSelectM(ctx, model, id, columns)
 }
 
-func (c *ModelFunc) linkKey(linkType, field string) string {
-	return fmt.Sprintf("%s%s:%s", c.RedisPrefix, linkType, field)
+func (c *ModelFunc) FirstByKey(ctx context.Context, model interface{}, id interface{}) (err error) {
+	_, err = c.firstByKey(ctx, model, id)
+	return c.notFound(err)
 }
 
-func (c *ModelFunc) getLink(ctx context.Context, linkType, field string) (string, error) {
-	if field == "" {
-		return "", errors.New("getLink 缺少参数 field")
+func (c *ModelFunc) firstByKey(ctx context.Context, model interface{}, id interface{}) (hit bool, err error) {
+	defer c.observeError("FirstById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return false, err
 	}
-	return c.RedisClient.WithContext(ctx).Get(ctx, c.linkKey(linkType, field)).Result()
-}
 
-func (c *ModelFunc) createLink(ctx context.Context, id uint64, linkType, field string) error {
-	if id == 0 {
-		return errors.New("createLink 缺少参数 id")
-	} else if field == "" {
-		return errors.New("createLink 缺少参数 field")
+	if c.readCache(ctx) {
+		hit, err = c.firstByIdR(ctx, model, id)
+		span.SetAttributes(hitAttr(hit))
+	} else {
+		err = c.firstByIdM(ctx, model, id)
 	}
-	return c.RedisClient.WithContext(ctx).Set(ctx, c.linkKey(linkType, field), id, time.Hour*24*7).Err()
+	return
 }
 
-func (c *ModelFunc) delLink(ctx context.Context, linkType, field string) error {
-	if field == "" {
-		return errors.New("delLink 缺少参数 field")
+func (c *ModelFunc) FirstByUnique(ctx context.Context, model interface{}, column string, value interface{}) (err error) {
+	defer c.observeError("FirstByUnique", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByUnique", attribute.String("mf.column", column))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
 	}
-	return c.RedisClient.WithContext(ctx).Del(ctx, c.linkKey(linkType, field)).Err()
-}
 
-func (c *ModelFunc) hook(hookMethod string, ctx context.Context, model interface{}) error {
-	a := reflect.ValueOf(model)
-	m := a.MethodByName(hookMethod)
-	if !m.IsValid() {
-		return nil
+	registered := false
+	for _, unique := range c.UniqueColumns {
+		registered = registered || unique == column
+	}
+	if !registered {
+		return fmt.Errorf("字段 %s 未在 UniqueColumns 中声明", column)
 	}
-	params := make([]reflect.Value, 3)
-	params[0] = reflect.ValueOf(ctx)
-	params[1] = reflect.ValueOf(c.MysqlCient)
-	params[2] = reflect.ValueOf(c.RedisClient)
 
-	values := m.Call(params)
-	switch values[0].Interface().(type) {
-	case error:
-		return values[0].Interface().(error)
-	default:
-		return nil
+	if c.readCache(ctx) {
+		err = c.firstByUniqueR(ctx, model, column, value)
+	} else {
+		err = c.firstByUniqueM(ctx, model, column, value)
+	}
+	return
+}
+
+// 使用id批量查询记录, 返回以id为key的 map, 不存在的id不返回, modelType 为模型或者模型指针, map 的值为模型指针
+func (c *ModelFunc) FirstByIdsMap(ctx context.Context, modelType interface{}, ids []uint64) (map[uint64]interface{}, error) {
+	t := reflect.TypeOf(modelType)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, errors.New("FirstByIdsMap 参数 modelType 必须是结构体或者结构体指针")
+	}
+	list := reflect.New(reflect.SliceOf(reflect.PtrTo(t)))
+	if err := c.FirstByIds(ctx, list.Interface(), ids); err != nil {
+		return nil, err
+	}
+	result := make(map[uint64]interface{}, list.Elem().Len())
+	for i := 0; i < list.Elem().Len(); i++ {
+		model := list.Elem().Index(i).Interface()
+		id, err := c.modelId(ctx, model)
+		if err != nil {
+			return nil, err
+		}
+		result[id] = model
+	}
+	return result, nil
+}
+
+func (c *ModelFunc) FirstByIds(ctx context.Context, models interface{}, ids []uint64) (err error) {
+	defer c.observeError("FirstByIds", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIds", attribute.Int("mf.ids", len(ids)))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	list := reflect.ValueOf(models)
+	if list.Kind() != reflect.Ptr || list.Elem().Kind() != reflect.Slice {
+		return errors.New("FirstByIds 参数 models 必须是切片指针")
+	}
+	if len(ids) == 0 {
+		list.Elem().Set(reflect.MakeSlice(list.Elem().Type(), 0, 0))
+		return nil
+	}
+
+	if c.readCache(ctx) {
+		err = c.firstByIdsR(ctx, models, ids)
+	} else {
+		err = c.firstByIdsM(ctx, models, ids)
+	}
+	return
+}
+
+func (c *ModelFunc) FirstByLink(ctx context.Context, linkType string, model interface{}, field string) error {
+	_, err := c.firstByLink(ctx, linkType, model, field)
+	return err
+}
+
+// 使用link查询记录, 并返回link解析出的id, 便于之后使用id继续操作
+func (c *ModelFunc) FirstByLinkWithId(ctx context.Context, linkType string, model interface{}, field string) (uint64, error) {
+	return c.firstByLink(ctx, linkType, model, field)
+}
+
+func (c *ModelFunc) firstByLink(ctx context.Context, linkType string, model interface{}, field string) (id uint64, err error) {
+	defer c.observeError("FirstByLink", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByLink", attribute.String("mf.link_type", linkType))
+	defer func() { endSpan(span, err) }()
+
+	finder, exist := c.LinkMap[linkType]
+	if !exist {
+		return 0, fmt.Errorf("%w: %s", ErrLinkTypeNotFound, linkType)
+	}
+	cacheRow := c.LinkCacheRow && c.linkCache(ctx)
+	if cacheRow {
+		// 直接读取link缓存的整条记录
+		if err = c.loadCache(ctx, c.linkRowKey(linkType, field), model); err == nil {
+			c.observeCache("FirstByLink", true)
+			c.logger().Debug("link row cache hit", "key", c.linkRowKey(linkType, field))
+			return c.modelId(ctx, model)
+		} else if !ErrIsRedisNil(err) && c.cacheErr("FirstByLink", err) != nil {
+			return 0, err
+		}
+		c.observeCache("FirstByLink", false)
+	}
+
+	id, err = c.resolveLink(ctx, linkType, field, func() (uint64, error) {
+		return finder.Find(ctx, c.db(ctx), field)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("linkType %s 的 %s 不存在对应的记录: %w", linkType, field, gorm.ErrRecordNotFound)
+	}
+	if _, err = c.firstByKey(ctx, model, id); err != nil || !cacheRow {
+		return id, err
+	}
+	return id, c.cacheErr("FirstByLink", c.setCache(ctx, c.linkRowKey(linkType, field), model))
+}
+
+// 使用多个link值批量查询记录, 按 fields 顺序返回, 不存在的记录不返回
+func (c *ModelFunc) FirstByLinks(ctx context.Context, linkType string, models interface{}, fields []string) (err error) {
+	defer c.observeError("FirstByLinks", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByLinks", attribute.String("mf.link_type", linkType), attribute.Int("mf.fields", len(fields)))
+	defer func() { endSpan(span, err) }()
+
+	finder, exist := c.LinkMap[linkType]
+	if !exist {
+		return fmt.Errorf("%w: %s", ErrLinkTypeNotFound, linkType)
+	}
+	ids, err := c.resolveLinks(ctx, linkType, fields, finder)
+	if err != nil {
+		return err
+	}
+	return c.FirstByIds(ctx, models, ids)
+}
+
+func (c *ModelFunc) FirstByLinkFields(ctx context.Context, linkType string, model interface{}, fields map[string]string) (err error) {
+	defer c.observeError("FirstByLinkFields", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByLinkFields", attribute.String("mf.link_type", linkType))
+	defer func() { endSpan(span, err) }()
+
+	finder, exist := c.LinkMap[linkType]
+	if !exist {
+		return fmt.Errorf("%w: %s", ErrLinkTypeNotFound, linkType)
+	}
+	composite, ok := finder.(CompositeLinkFinder)
+	if !ok {
+		return fmt.Errorf("linkType %s 不支持多字段查询", linkType)
+	}
+	field := LinkFieldsValue(fields)
+	id, err := c.resolveLink(ctx, linkType, field, func() (uint64, error) {
+		return composite.FindFields(ctx, c.db(ctx), fields)
+	})
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return fmt.Errorf("linkType %s 的 %s 不存在对应的记录: %w", linkType, field, gorm.ErrRecordNotFound)
+	}
+	_, err = c.firstByKey(ctx, model, id)
+	return err
+}
+
+func (c *ModelFunc) FirstByIdSD(ctx context.Context, model interface{}, id uint64) error {
+	return c.FirstByKeySD(ctx, model, id)
+}
+
+func (c *ModelFunc) FirstByKeySD(ctx context.Context, model interface{}, id interface{}) (err error) {
+	defer c.observeError("FirstByIdSD", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByIdSD", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if c.readCache(ctx) {
+		err = c.firstByIdFilterSoftDelR(ctx, model, id)
+	} else {
+		err = c.firstByIdFilterSoftDelM(ctx, model, id)
+	}
+	return
+}
+
+func (c *ModelFunc) FirstByLinkSD(ctx context.Context, linkType string, model interface{}, field string) (err error) {
+	defer c.observeError("FirstByLinkSD", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FirstByLinkSD", attribute.String("mf.link_type", linkType))
+	defer func() { endSpan(span, err) }()
+
+	finder, exist := c.LinkMap[linkType]
+	if !exist {
+		return fmt.Errorf("%w: %s", ErrLinkTypeNotFound, linkType)
+	}
+	id, err := c.resolveLink(ctx, linkType, field, func() (uint64, error) {
+		return finder.Find(ctx, c.db(ctx), field)
+	})
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return fmt.Errorf("linkType %s 的 %s 不存在对应的记录: %w", linkType, field, gorm.ErrRecordNotFound)
+	}
+	return c.FirstByIdSD(ctx, model, id)
+}
+
+// 模型包含 gorm.DeletedAt 字段时 gorm 会自动软删, 需要物理删除时使用 HardDeleteById
+func (c *ModelFunc) DeleteById(ctx context.Context, model interface{}, id uint64) error {
+	return c.DeleteByKey(ctx, model, id)
+}
+
+func (c *ModelFunc) DeleteByKey(ctx context.Context, model interface{}, id interface{}) error {
+	_, err := c.deleteByKey(ctx, model, id)
+	return err
+}
+
+// 返回删除影响的行数, 可以结合条件判断记录是否被其他请求修改
+func (c *ModelFunc) DeleteByIdWithCount(ctx context.Context, model interface{}, id uint64) (int64, error) {
+	return c.deleteByKey(ctx, model, id)
+}
+
+func (c *ModelFunc) deleteByKey(ctx context.Context, model interface{}, id interface{}) (rows int64, err error) {
+	defer c.observeError("DeleteById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "DeleteById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return 0, err
+	}
+
+	if c.DryRun {
+		return c.dryRun(ctx, "DeleteById", model, c.wherePk(id), func(tx *gorm.DB) *gorm.DB {
+			return tx.Delete(model)
+		})
+	}
+
+	if err = c.hook("MfBeforeDeleteById", ctx, model, id); err != nil {
+		return 0, err
+	}
+
+	if c.UseCache {
+		rows, err = c.deleteByIdR(ctx, model, id)
+	} else {
+		rows, err = c.deleteByIdM(ctx, model, id)
+	}
+	if err != nil {
+		return rows, err
+	}
+	return rows, c.hook("MfAfterDeleteById", ctx, model, id)
+}
+
+func (c *ModelFunc) HardDeleteById(ctx context.Context, model interface{}, id uint64) error {
+	return c.HardDeleteByKey(ctx, model, id)
+}
+
+// 使用 Unscoped 物理删除记录, 不受 gorm.DeletedAt 影响
+func (c *ModelFunc) HardDeleteByKey(ctx context.Context, model interface{}, id interface{}) (err error) {
+	defer c.observeError("HardDeleteById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "HardDeleteById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		_, err = c.dryRun(ctx, "HardDeleteById", model, c.wherePk(id), func(tx *gorm.DB) *gorm.DB {
+			return tx.Unscoped().Delete(model)
+		})
+		return err
+	}
+
+	if err = c.hook("MfBeforeHardDeleteById", ctx, model, id); err != nil {
+		return err
+	}
+
+	if c.UseCache {
+		err = c.hardDeleteByIdR(ctx, model, id)
+	} else {
+		err = c.hardDeleteByIdM(ctx, model, id)
+	}
+	if err != nil {
+		return err
+	}
+	return c.hook("MfAfterHardDeleteById", ctx, model, id)
+}
+
+func (c *ModelFunc) DeleteByLink(ctx context.Context, linkType string, model interface{}, field string) (err error) {
+	defer c.observeError("DeleteByLink", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "DeleteByLink", attribute.String("mf.link_type", linkType))
+	defer func() { endSpan(span, err) }()
+
+	finder, exist := c.LinkMap[linkType]
+	if !exist {
+		return fmt.Errorf("%w: %s", ErrLinkTypeNotFound, linkType)
+	}
+	find := func() (uint64, error) {
+		return finder.Find(ctx, c.db(ctx), field)
+	}
+	var id uint64
+	if c.DryRun {
+		// 只预览, 不写入link缓存
+		id, err = find()
+	} else {
+		id, err = c.resolveLink(ctx, linkType, field, find)
+	}
+	if err != nil {
+		return err
+	} else if id == 0 {
+		return fmt.Errorf("linkType %s 的 %s 不存在对应的记录: %w", linkType, field, gorm.ErrRecordNotFound)
+	}
+
+	if err = c.DeleteById(ctx, model, id); err != nil {
+		return err
+	}
+
+	// 清除本次查询使用的link缓存
+	if nilRedis(c.RedisClient) || c.DryRun {
+		return nil
+	}
+	return c.cacheErr("DeleteByLink", c.delLink(ctx, linkType, field))
+}
+
+func (c *ModelFunc) SoftDeleteById(ctx context.Context, model interface{}, id uint64) error {
+	return c.SoftDeleteByKey(ctx, model, id)
+}
+
+func (c *ModelFunc) SoftDeleteByKey(ctx context.Context, model interface{}, id interface{}) error {
+	_, err := c.softDeleteByKey(ctx, model, id)
+	return err
+}
+
+// 返回软删影响的行数, 可以结合条件判断记录是否被其他请求修改
+func (c *ModelFunc) SoftDeleteByIdWithCount(ctx context.Context, model interface{}, id uint64) (int64, error) {
+	return c.softDeleteByKey(ctx, model, id)
+}
+
+func (c *ModelFunc) softDeleteByKey(ctx context.Context, model interface{}, id interface{}) (rows int64, err error) {
+	defer c.observeError("SoftDeleteById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "SoftDeleteById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return 0, err
+	}
+
+	if c.DryRun {
+		return c.dryRun(ctx, "SoftDeleteById", model, c.wherePk(id), func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(model).Updates(map[string]interface{}{c.softDeleteColumn(): c.now()})
+		})
+	}
+
+	if err = c.hook("MfBeforeSoftDeleteById", ctx, model, id); err != nil {
+		return 0, err
+	}
+
+	if c.UseCache {
+		rows, err = c.softDeleteByIdR(ctx, model, id)
+	} else {
+		rows, err = c.softDeleteByIdM(ctx, model, id)
+	}
+	if err != nil {
+		return rows, err
+	}
+	return rows, c.hook("MfAfterSoftDeleteById", ctx, model, id)
+}
+
+// 批量软删, MfBeforeSoftDeleteById 和 MfAfterSoftDeleteById 钩子按id逐条执行, 钩子收到的 model 是传入的 model
+func (c *ModelFunc) SoftDeleteByIds(ctx context.Context, model interface{}, ids []uint64) (err error) {
+	defer c.observeError("SoftDeleteByIds", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "SoftDeleteByIds", attribute.Int("mf.ids", len(ids)))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if c.DryRun {
+		where := func(tx *gorm.DB) *gorm.DB {
+			return tx.Where(c.primaryKey()+" IN ?", ids)
+		}
+		_, err = c.dryRun(ctx, "SoftDeleteByIds", model, where, func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(model).Updates(map[string]interface{}{c.softDeleteColumn(): c.now()})
+		})
+		return err
+	}
+
+	if err = c.batchHook("MfBeforeSoftDeleteByIds", ctx, model, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = c.hook("MfBeforeSoftDeleteById", ctx, model, id); err != nil {
+			return err
+		}
+	}
+
+	if c.UseCache {
+		err = c.softDeleteByIdsR(ctx, model, ids)
+	} else {
+		err = c.softDeleteByIdsM(ctx, model, ids)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = c.hook("MfAfterSoftDeleteById", ctx, model, id); err != nil {
+			return err
+		}
+	}
+	return c.batchHook("MfAfterSoftDeleteByIds", ctx, model, ids)
+}
+
+func (c *ModelFunc) RestoreById(ctx context.Context, model interface{}, id uint64) error {
+	return c.RestoreByKey(ctx, model, id)
+}
+
+func (c *ModelFunc) RestoreByKey(ctx context.Context, model interface{}, id interface{}) (err error) {
+	defer c.observeError("RestoreById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "RestoreById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.hook("MfBeforeRestoreById", ctx, model, id); err != nil {
+		return err
+	}
+
+	if c.UseCache {
+		err = c.restoreByIdR(ctx, model, id)
+	} else {
+		err = c.restoreByIdM(ctx, model, id)
+	}
+	if err != nil {
+		return err
+	}
+	return c.hook("MfAfterRestoreById", ctx, model, id)
+}
+
+// 只把 UpdatedAtColumn 更新为 Now 返回的当前时间, 然后清除缓存, 不执行钩子
+func (c *ModelFunc) Touch(ctx context.Context, model interface{}, id uint64) (err error) {
+	defer c.observeError("Touch", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "Touch", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.touchM(ctx, model, id); err != nil {
+		return err
+	}
+	if c.UseCache {
+		return c.cacheErr("Touch", c.invalidate(ctx, model, id))
+	}
+	return nil
+}
+
+func (c *ModelFunc) WarmById(ctx context.Context, model interface{}, id uint64) (err error) {
+	defer c.observeError("WarmById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "WarmById")
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if !c.UseCache {
+		return errors.New("WarmById 需要开启 UseCache")
+	}
+	if err = c.firstByIdM(ctx, model, id); err != nil {
+		return err
+	}
+	return c.updateCache(ctx, model, id)
+}
+
+func (c *ModelFunc) WarmByIds(ctx context.Context, models interface{}, ids []uint64) (err error) {
+	defer c.observeError("WarmByIds", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "WarmByIds")
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if !c.UseCache {
+		return errors.New("WarmByIds 需要开启 UseCache")
+	}
+	list := reflect.ValueOf(models)
+	if list.Kind() != reflect.Ptr || list.Elem().Kind() != reflect.Slice {
+		return errors.New("WarmByIds 参数 models 必须是切片指针")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err = c.firstByIdsM(ctx, models, ids); err != nil {
+		return err
+	}
+	if c.tx(ctx) != nil {
+		// 事务中的数据还没有提交, 改为提交后清除这些记录的缓存
+		keys := make([]string, 0, list.Elem().Len())
+		for i := 0; i < list.Elem().Len(); i++ {
+			row := modelPtr(list.Elem().Index(i))
+			id, err := c.modelId(ctx, row)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, c.cacheKey(row, id))
+		}
+		return c.delKeys(ctx, keys...)
+	}
+
+	// 先序列化所有记录, 再使用 pipeline 批量写入缓存, 过大的值删除旧缓存
+	type warmEntry struct {
+		key    string
+		data   []byte
+		expire time.Duration
+	}
+	entries := make([]warmEntry, 0, list.Elem().Len())
+	for i := 0; i < list.Elem().Len(); i++ {
+		row := modelPtr(list.Elem().Index(i))
+		id, err := c.modelId(ctx, row)
+		if err != nil {
+			return err
+		}
+		marshalData, err := c.encode(row)
+		if err != nil {
+			return err
+		}
+		key := c.cacheKey(row, id)
+		c.removeLocal(key)
+		if c.cacheTooLarge(key, marshalData) {
+			marshalData = nil
+		}
+		entries = append(entries, warmEntry{key: key, data: marshalData, expire: c.cacheExpire(row)})
+	}
+	err = c.retry(ctx, func() error {
+		pipe := c.RedisClient.Pipeline()
+		for _, e := range entries {
+			if e.data == nil {
+				pipe.Del(ctx, e.key)
+			} else {
+				pipe.Set(ctx, e.key, string(e.data), e.expire)
+			}
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	return c.cacheErr("WarmByIds", err)
+}
+
+// 重新设置id缓存的过期时间, 不读写数据库, 缓存不存在时不处理
+func (c *ModelFunc) ExpireById(ctx context.Context, model interface{}, id uint64, ttl time.Duration) (err error) {
+	defer c.observeError("ExpireById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "ExpireById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if !c.UseCache {
+		return errors.New("ExpireById 需要开启 UseCache")
+	}
+	var exist bool
+	err = c.retry(ctx, func() (err error) {
+		exist, err = c.RedisClient.Expire(ctx, c.cacheKey(model, id), ttl).Result()
+		return err
+	})
+	if err == nil {
+		// 缓存存在时记为命中
+		c.observeCache("ExpireById", exist)
+	}
+	return err
+}
+
+// 只清除缓存, 不读写数据库, 用于收到其他服务的变更通知后保持缓存一致, model 用于生成link缓存和唯一字段缓存的key
+func (c *ModelFunc) InvalidateById(ctx context.Context, model interface{}, id uint64) (err error) {
+	defer c.observeError("InvalidateById", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "InvalidateById", idAttr(id))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if !c.UseCache {
+		return errors.New("InvalidateById 需要开启 UseCache")
+	}
+	return c.invalidate(ctx, model, id)
+}
+
+// 批量预热link缓存, 已经存在的link跳过, 不存在对应记录的 field 不缓存
+func (c *ModelFunc) WarmLinks(ctx context.Context, linkType string, fields []string) (err error) {
+	defer c.observeError("WarmLinks", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "WarmLinks", attribute.String("mf.link_type", linkType), attribute.Int("mf.fields", len(fields)))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if nilRedis(c.RedisClient) {
+		return errors.New("WarmLinks 需要设置 RedisClient")
+	}
+	finder, exist := c.LinkMap[linkType]
+	if !exist {
+		return fmt.Errorf("%w: %s", ErrLinkTypeNotFound, linkType)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// 批量读取已经存在的link
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = c.linkKey(linkType, field)
+	}
+	values, err := c.mget(ctx, keys...)
+	if err != nil {
+		return err
+	}
+
+	// 使用 pipeline 批量写入link
+	pipe := c.RedisClient.Pipeline()
+	for i, field := range fields {
+		if values[i] != nil || field == "" {
+			continue
+		}
+		id, err := finder.Find(ctx, c.db(ctx), field)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			continue
+		}
+		pipe.SetNX(ctx, keys[i], id, c.linkExpire(linkType))
+	}
+	if pipe.Len() == 0 {
+		return nil
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 使用 SCAN 查找 RedisPrefix 开头的所有key并分批删除, 用于清空整个模型的缓存
+func (c *ModelFunc) FlushPrefix(ctx context.Context) (err error) {
+	defer c.observeError("FlushPrefix", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "FlushPrefix")
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if nilRedis(c.RedisClient) {
+		return errors.New("FlushPrefix 需要设置 RedisClient")
+	}
+	// 前缀为空时会删除整个库的key
+	if c.RedisPrefix == "" {
+		return errors.New("FlushPrefix 需要设置 RedisPrefix")
+	}
+	if c.LocalCache != nil {
+		c.LocalCache.removePrefix(c.RedisPrefix)
+	}
+
+	match := escapePattern(c.RedisPrefix) + "*"
+	// 集群和分片模式下 SCAN 只扫描一个节点, 需要逐个节点扫描
+	switch rdc := c.RedisClient.(type) {
+	case *redis.ClusterClient:
+		return rdc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return c.flushNode(ctx, node, match)
+		})
+	case *redis.Ring:
+		return rdc.ForEachShard(ctx, func(ctx context.Context, node *redis.Client) error {
+			return c.flushNode(ctx, node, match)
+		})
+	}
+	return c.flushNode(ctx, c.RedisClient, match)
+}
+
+// 扫描一个节点上匹配 match 的key并分批删除
+func (c *ModelFunc) flushNode(ctx context.Context, node redis.UniversalClient, match string) error {
+	var cursor uint64
+	for {
+		var keys []string
+		err := c.retry(ctx, func() (err error) {
+			keys, cursor, err = node.Scan(ctx, cursor, match, 1000).Result()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err = c.delKeys(ctx, keys...); err != nil {
+			return err
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// 使用配置的序列化方式把任意值写入 RedisPrefix + key, 用于缓存原生 SQL 等查询的结果, ttl 为0时永不过期
+// key 需要避免与 id 缓存、link缓存的格式冲突, FlushPrefix 会一起删除
+func (c *ModelFunc) SetCache(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error) {
+	defer c.observeError("SetCache", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if nilRedis(c.RedisClient) {
+		return errors.New("SetCache 需要设置 RedisClient")
+	}
+	data, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+	key = c.RedisPrefix + key
+	if c.cacheTooLarge(key, data) {
+		// 与更新缓存相同, 不写入过大的值并清除旧值
+		return c.delKeys(ctx, key)
+	}
+	return c.retry(ctx, func() error {
+		return c.RedisClient.Set(ctx, key, string(data), ttl).Err()
+	})
+}
+
+// 读取 SetCache 写入的值到 dest, dest 必须是指针, 不存在时返回 false
+func (c *ModelFunc) GetCache(ctx context.Context, key string, dest interface{}) (found bool, err error) {
+	defer c.observeError("GetCache", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if nilRedis(c.RedisClient) {
+		return false, errors.New("GetCache 需要设置 RedisClient")
+	}
+	var res string
+	err = c.retry(ctx, func() (err error) {
+		res, err = c.RedisClient.Get(ctx, c.RedisPrefix+key).Result()
+		return err
+	})
+	if ErrIsRedisNil(err) {
+		c.observeCache("GetCache", false)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	c.observeCache("GetCache", true)
+	return true, c.decode([]byte(res), dest)
+}
+
+// 只清除link缓存
+func (c *ModelFunc) InvalidateLink(ctx context.Context, linkType, field string) (err error) {
+	defer c.observeError("InvalidateLink", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "InvalidateLink", attribute.String("mf.link_type", linkType))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	if nilRedis(c.RedisClient) {
+		return errors.New("InvalidateLink 需要设置 RedisClient")
+	}
+	return c.delLink(ctx, linkType, field)
+}
+
+func (c *ModelFunc) Count(ctx context.Context, model interface{}, conds ...interface{}) (count int64, err error) {
+	defer c.observeError("Count", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "Count")
+
+	if err = c.Validate(); err != nil {
+		return 0, err
+	}
+
+	db := c.db(ctx).Model(model)
+	if len(conds) > 0 {
+		db = db.Where(conds[0], conds[1:]...)
+	}
+	start := time.Now()
+	err = db.Count(&count).Error
+	c.observeDB("Count", start)
+	return
+}
+
+// 统计link值对应的记录数, 大于1时 FirstByLink 只能返回其中一条, linkType 对应的 LinkFinder 需要实现 CountLinkFinder, 不走缓存
+func (c *ModelFunc) CountByLink(ctx context.Context, linkType string, field string) (count int64, err error) {
+	defer c.observeError("CountByLink", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "CountByLink", attribute.String("mf.link_type", linkType))
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return 0, err
+	}
+
+	finder, exist := c.LinkMap[linkType]
+	if !exist {
+		return 0, fmt.Errorf("%w: %s", ErrLinkTypeNotFound, linkType)
+	}
+	counter, ok := finder.(CountLinkFinder)
+	if !ok {
+		return 0, fmt.Errorf("linkType %s 不支持统计记录数", linkType)
+	}
+	defer c.observeDB("CountByLink", time.Now())
+	return counter.Count(ctx, c.db(ctx), field)
+}
+
+// 按条件查询多条记录, 条件无法生成缓存key, 因此不走缓存, 总是查询数据库
+func (c *ModelFunc) FindByCondition(ctx context.Context, models interface{}, conds ...interface{}) (err error) {
+	defer c.observeError("FindByCondition", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "FindByCondition")
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	defer c.observeDB("FindByCondition", time.Now())
+
+	db := c.db(ctx)
+	if len(conds) > 0 {
+		db = db.Where(conds[0], conds[1:]...)
+	}
+	return db.Find(models).Error
+}
+
+// 按条件删除记录, 返回删除的行数, 不执行钩子
+// 使用缓存时先查询匹配的记录, 再使用条件和查询到的id一起删除并清除缓存
+// 查询和删除之间新写入的匹配记录不会被删除, 被修改为不再匹配条件的记录也不会被删除, 返回的行数为实际删除的行数
+func (c *ModelFunc) DeleteByCondition(ctx context.Context, model interface{}, conds ...interface{}) (rows int64, err error) {
+	defer c.observeError("DeleteByCondition", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "DeleteByCondition")
+	defer func() { endSpan(span, err) }()
+
+	if err = c.Validate(); err != nil {
+		return 0, err
+	}
+	if len(conds) == 0 {
+		return 0, errors.New("DeleteByCondition 缺少删除条件")
+	}
+
+	if c.DryRun {
+		where := func(tx *gorm.DB) *gorm.DB {
+			return tx.Where(conds[0], conds[1:]...)
+		}
+		return c.dryRun(ctx, "DeleteByCondition", model, where, func(tx *gorm.DB) *gorm.DB {
+			return tx.Delete(model)
+		})
+	}
+
+	if c.UseCache {
+		return c.deleteByConditionR(ctx, model, conds...)
+	}
+	return c.deleteByConditionM(ctx, model, conds...)
+}
+
+func (c *ModelFunc) Paginate(ctx context.Context, models interface{}, page, pageSize int, conds ...interface{}) (total int64, err error) {
+	defer c.observeError("Paginate", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "Paginate")
+
+	if err = c.Validate(); err != nil {
+		return 0, err
+	}
+
+	if page < 1 {
+		return 0, errors.New("Paginate 参数 page 必须大于等于1")
+	} else if pageSize < 1 {
+		return 0, errors.New("Paginate 参数 pageSize 必须大于等于1")
+	}
+	if pageSize > c.maxPageSize() {
+		pageSize = c.maxPageSize()
+	}
+
+	query := func() *gorm.DB {
+		db := c.db(ctx).Model(models)
+		if len(conds) > 0 {
+			db = db.Where(conds[0], conds[1:]...)
+		}
+		return db
+	}
+
+	defer c.observeDB("Paginate", time.Now())
+	if err = query().Count(&total).Error; err != nil {
+		return 0, err
+	}
+	err = query().Order(c.primaryKey()).Offset((page - 1) * pageSize).Limit(pageSize).Find(models).Error
+	return
+}
+
+func (c *ModelFunc) Exists(ctx context.Context, model interface{}, id uint64) (exist bool, err error) {
+	defer c.observeError("Exists", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ctx = withOp(ctx, "Exists")
+
+	if err = c.Validate(); err != nil {
+		return false, err
+	}
+
+	if c.readCache(ctx) {
+		exist, err = c.existsR(ctx, model, id)
+	} else {
+		exist, err = c.existsM(ctx, model, id)
+	}
+	return
+}
+
+// 已注册的 linkType, 按名称排序
+func (c *ModelFunc) LinkTypes() []string {
+	linkTypes := make([]string, 0, len(c.LinkMap))
+	for linkType := range c.LinkMap {
+		linkTypes = append(linkTypes, linkType)
+	}
+	sort.Strings(linkTypes)
+	return linkTypes
+}
+
+// 检查 LinkMap 中的 LinkFinder 是否为空, 包括值为 nil 的指针, 避免第一次使用时才 panic
+func (c *ModelFunc) ValidateLinks() error {
+	var errs []error
+	for _, linkType := range c.LinkTypes() {
+		finder := c.LinkMap[linkType]
+		if finder == nil {
+			errs = append(errs, fmt.Errorf("linkType %s 的 LinkFinder 为空", linkType))
+			continue
+		}
+		rv := reflect.ValueOf(finder)
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Func, reflect.Interface, reflect.Slice, reflect.Chan:
+			if rv.IsNil() {
+				errs = append(errs, fmt.Errorf("linkType %s 的 LinkFinder 为空的 %s", linkType, rv.Type()))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 检查配置, 开启缓存但没有设置 RedisClient 时返回错误, 避免使用时 panic
+func (c *ModelFunc) Validate() error {
+	if c.MysqlCient == nil {
+		return errors.New("MysqlCient 不能为空")
+	}
+	if c.UseCache && nilRedis(c.RedisClient) {
+		return errors.New("UseCache 为 true 但 RedisClient 为空")
+	}
+	return nil
+}
+
+// rdc 是否为空, 包括值为 nil 的 *redis.Client 等指针, 这种 rdc 不等于 nil 但调用时会 panic
+func nilRedis(rdc redis.UniversalClient) bool {
+	if rdc == nil {
+		return true
+	}
+	rv := reflect.ValueOf(rdc)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// 检查数据库和 redis 连接, 用于健康检查, 返回的错误中标明不可用的服务
+func (c *ModelFunc) Ping(ctx context.Context) (err error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+
+	var errs []error
+	sqlDB, err := c.MysqlCient.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("mysql 不可用: %w", err))
+	}
+	if c.UseCache {
+		if err = c.RedisClient.Ping(ctx).Err(); err != nil {
+			errs = append(errs, fmt.Errorf("redis 不可用: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *ModelFunc) primaryKey() string {
+	if c.PrimaryKey == "" {
+		return "id"
+	}
+	return c.PrimaryKey
+}
+
+func (c *ModelFunc) maxPageSize() int {
+	if c.MaxPageSize <= 0 {
+		return 100
+	}
+	return c.MaxPageSize
+}
+
+// 返回 Location 时区的当前时间
+func (c *ModelFunc) now() time.Time {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	if c.Location == nil {
+		return now().UTC()
+	}
+	return now().In(c.Location)
+}
+
+// 设置了 DefaultTimeout 时派生带超时的 ctx, 调用方需要 defer cancel()
+func (c *ModelFunc) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.DefaultTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.DefaultTimeout)
+}
+
+// 使用主键作为条件的 scope
+func (c *ModelFunc) wherePk(id interface{}) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(c.primaryKey()+" = ?", id)
+	}
+}
+
+// 预览写入, 统计 where 匹配的行数, 使用 Logger 输出 write 生成的 SQL, 不执行写入
+func (c *ModelFunc) dryRun(ctx context.Context, op string, model interface{}, where, write func(*gorm.DB) *gorm.DB) (int64, error) {
+	sql := c.MysqlCient.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return write(where(tx.WithContext(ctx)))
+	})
+
+	var count int64
+	start := time.Now()
+	err := where(c.db(ctx).Model(model)).Count(&count).Error
+	c.observeDB(op, start)
+	if err != nil {
+		return 0, err
+	}
+	c.logger().Debug("dry run", "op", op, "sql", sql, "rows", count)
+	return count, nil
+}
+
+// 强制读主库的 scope, 默认设置 dbresolver 的写库标记, 没有使用 dbresolver 时不影响查询
+func (c *ModelFunc) primaryScope() func(*gorm.DB) *gorm.DB {
+	if c.PrimaryScope != nil {
+		return c.PrimaryScope
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set("gorm:db_resolver:write", struct{}{})
+	}
+}
+
+func (c *ModelFunc) updatedAtColumn() string {
+	if c.UpdatedAtColumn == "" {
+		return "updated_at"
+	}
+	return c.UpdatedAtColumn
+}
+
+func (c *ModelFunc) softDeleteColumn() string {
+	if c.SoftDeleteColumn == "" {
+		return "deleted_at"
+	}
+	return c.SoftDeleteColumn
+}
+
+// 过滤被软删的记录
+func (c *ModelFunc) notDeleted(db *gorm.DB) *gorm.DB {
+	if c.SoftDeleteStyle == NullMeansActive {
+		return db.Where(c.softDeleteColumn() + " IS NULL")
+	}
+	return db.Where(c.softDeleteColumn()+" = ?", time.Time{})
+}
+
+// 软删字段未删除时的值
+func (c *ModelFunc) activeValue() interface{} {
+	if c.SoftDeleteStyle == NullMeansActive {
+		return nil
+	}
+	return time.Time{}
+}
+
+func (c *ModelFunc) codec() Codec {
+	if c.Codec == nil {
+		return JsonCodec{}
+	}
+	return c.Codec
+}
+
+// 缓存过期间隔, 设置 ExpireJitter 时加上随机抖动, 抖动后不大于0时使用 Expire 或 ExpireMap 中的值
+func (c *ModelFunc) cacheExpire(model interface{}) time.Duration {
+	c.expireMu.RLock()
+	base := c.Expire
+	c.expireMu.RUnlock()
+	if len(c.ExpireMap) > 0 {
+		if expire, exist := c.ExpireMap[c.tableName(model)]; exist {
+			base = expire
+		}
+	}
+	if base <= 0 || c.ExpireJitter <= 0 {
+		return base
+	}
+	expire := base + time.Duration(rand.Int63n(int64(c.ExpireJitter)*2+1)) - c.ExpireJitter
+	if expire <= 0 {
+		return base
+	}
+	return expire
+}
+
+func (c *ModelFunc) cacheKey(model interface{}, id interface{}) string {
+	prefix := c.keyPrefix(model)
+	if c.CacheVersion != "" {
+		prefix += c.CacheVersion + ":"
+	}
+	if c.KeyFunc != nil {
+		return c.KeyFunc(prefix, id)
+	}
+	return prefix + c.primaryKey() + ":" + keyString(id)
+}
+
+// 部分字段查询的缓存key, 使用 hash 保存不同字段组合的结果, 更新记录时整个删除
+func (c *ModelFunc) selectKey(model interface{}, id interface{}) string {
+	return c.cacheKey(model, id) + ":sel"
+}
+
+// FirstByIdSD 的缓存key, 与 FirstById 的缓存分开保存, FirstById 的缓存可能包含已软删的记录
+func (c *ModelFunc) sdKey(model interface{}, id interface{}) string {
+	return c.cacheKey(model, id) + ":sd"
+}
+
+// 字段组合在 hash 中的 field, 排序后拼接, 相同的字段组合使用同一个 field
+func selectField(columns []string) string {
+	sorted := append([]string(nil), columns...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// 唯一字段缓存key
+func (c *ModelFunc) uniqueKey(model interface{}, column string, value interface{}) string {
+	return c.keyPrefix(model) + "unique:" + column + ":" + keyString(value)
+}
+
+// 缓存key前缀, 开启 QualifyTable 时拼接模型的表名, 多个模型可以共用一个 RedisPrefix
+func (c *ModelFunc) keyPrefix(model interface{}) string {
+	if !c.QualifyTable {
+		return c.RedisPrefix
+	}
+	table := c.tableName(model)
+	if table == "" {
+		return c.RedisPrefix
+	}
+	return c.RedisPrefix + table + ":"
+}
+
+// 模型对应的表名, 解析失败时返回空字符串
+func (c *ModelFunc) tableName(model interface{}) string {
+	stmt := &gorm.Statement{DB: c.MysqlCient}
+	if err := stmt.Parse(model); err != nil {
+		return ""
+	}
+	return stmt.Schema.Table
+}
+
+// 开启 CacheFailOpen 时 redis 错误只记录到指标, 返回 nil 使请求继续执行
+func (c *ModelFunc) cacheErr(op string, err error) error {
+	if err == nil || !c.CacheFailOpen {
+		return err
+	}
+	c.metrics().ObserveError(op, err)
+	c.logger().Debug("redis error ignored", "op", op, "err", err)
+	return nil
+}
+
+// 清除id缓存、link缓存和唯一字段缓存
+// extra 为需要一起清除的其他key, 例如 staleKeys 返回的更新前的link缓存
+func (c *ModelFunc) invalidate(ctx context.Context, model interface{}, id interface{}, extra ...string) error {
+	return c.delKeys(ctx, append(c.invalidateKeys(ctx, model, id), extra...)...)
+}
+
+// 写入数据库后直接用模型覆盖id缓存, link、唯一字段和部分字段缓存仍然清除
+func (c *ModelFunc) writeThrough(ctx context.Context, model interface{}, id interface{}, extra ...string) error {
+	// invalidateKeys 的第一个key为id缓存
+	if err := c.delKeys(ctx, append(c.invalidateKeys(ctx, model, id)[1:], extra...)...); err != nil {
+		return err
+	}
+	return c.updateCache(ctx, model, id)
+}
+
+// 更新前查询数据库中的原记录, 生成原link值和唯一字段值对应的缓存key
+// 更新改变了link字段时, 模型中只有新的值, 旧值对应的link缓存仍然指向该id, 需要一起清除
+// 删除、软删和恢复传入的模型通常是空的, 同样使用原记录生成key, 包括已经被 gorm 软删的记录
+func (c *ModelFunc) staleKeys(ctx context.Context, model interface{}, id interface{}) []string {
+	if len(c.LinkMap) == 0 && len(c.UniqueColumns) == 0 {
+		return nil
+	}
+	old := reflect.New(reflect.Indirect(reflect.ValueOf(model)).Type()).Interface()
+	start := time.Now()
+	err := c.db(ctx).Unscoped().Where(c.primaryKey()+" = ?", id).First(old).Error
+	c.observeDB("staleKeys", start)
+	if err != nil {
+		// 记录不存在时没有旧的缓存
+		return nil
+	}
+	return c.invalidateKeys(ctx, old, id)
+}
+
+// 记录更新后需要清除的缓存key
+func (c *ModelFunc) invalidateKeys(ctx context.Context, model interface{}, id interface{}) []string {
+	keys := []string{c.cacheKey(model, id), c.selectKey(model, id), c.sdKey(model, id)}
+	for linkType, linkFunc := range c.LinkMap {
+		for _, field := range linkFieldValues(linkFunc, model) {
+			keys = append(keys, c.linkKey(linkType, field), c.linkRowKey(linkType, field))
+		}
+	}
+	return append(keys, c.uniqueKeys(ctx, model)...)
+}
+
+// 模型对应的所有link值, 实现 MultiLinkFinder 时同时包括 FieldValues 返回的值, 空值跳过
+func linkFieldValues(finder LinkFinder, model interface{}) []string {
+	fields := []string{finder.FieldValue(model)}
+	if multi, ok := finder.(MultiLinkFinder); ok {
+		fields = append(fields, multi.FieldValues(model)...)
+	}
+
+	values := fields[:0]
+	for _, field := range fields {
+		if field != "" {
+			values = append(values, field)
+		}
+	}
+	return values
+}
+
+// 根据模型中 UniqueColumns 字段的值生成唯一字段缓存key, 零值字段跳过
+func (c *ModelFunc) uniqueKeys(ctx context.Context, model interface{}) []string {
+	if len(c.UniqueColumns) == 0 {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: c.MysqlCient}
+	if err := stmt.Parse(model); err != nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(c.UniqueColumns))
+	for _, column := range c.UniqueColumns {
+		field := stmt.Schema.LookUpField(column)
+		if field == nil {
+			continue
+		}
+		if value, zero := field.ValueOf(ctx, reflect.Indirect(reflect.ValueOf(model))); !zero {
+			keys = append(keys, c.uniqueKey(model, column, value))
+		}
+	}
+	return keys
+}
+
+// 批量读取缓存, 不存在的key返回 nil
+// 集群模式下key分布在不同的 slot, 分片模式下 MGET 只发送到第一个key所在的分片, 除单节点以外都使用 pipeline GET 代替 MGET
+func (c *ModelFunc) mget(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if _, ok := c.RedisClient.(*redis.Client); ok {
+		return c.RedisClient.MGet(ctx, keys...).Result()
+	}
+
+	pipe := c.RedisClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	_, _ = pipe.Exec(ctx)
+
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if ErrIsRedisNil(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// 使用 pipeline 批量删除缓存, 一次网络往返, 汇总返回所有错误
+func (c *ModelFunc) delKeys(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	// 在事务中时等到提交后再删除
+	if tx := c.tx(ctx); tx != nil {
+		tx.add(c, keys...)
+		return nil
+	}
+	c.removeLocal(keys...)
+	c.logger().Debug("invalidated cache", "keys", keys)
+
+	var cmds []redis.Cmder
+	err := c.retry(ctx, func() (err error) {
+		pipe := c.RedisClient.Pipeline()
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		cmds, err = pipe.Exec(ctx)
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+
+	errs := make([]error, 0, len(cmds))
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", cmd.Args(), cmd.Err()))
+		}
+	}
+	if len(errs) == 0 {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+func (c *ModelFunc) updateCache(ctx context.Context, model interface{}, id interface{}) error {
+	return c.setCache(ctx, c.cacheKey(model, id), model)
+}
+
+// 序列化模型写入指定的缓存key
+func (c *ModelFunc) setCache(ctx context.Context, key string, model interface{}) error {
+	// 事务中的数据还没有提交, 改为提交后清除该key
+	if c.tx(ctx) != nil {
+		return c.delKeys(ctx, key)
+	}
+	marshalData, err := c.encode(model)
+	if err != nil {
+		return err
+	}
+	if c.cacheTooLarge(key, marshalData) {
+		// 不缓存过大的值, 同时清除旧的缓存, 之后的查询直接回源数据库
+		return c.delKeys(ctx, key)
+	}
+
+	c.removeLocal(key)
+	return c.retry(ctx, func() error {
+		return c.RedisClient.Set(ctx, key, string(marshalData), c.cacheExpire(model)).Err()
+	})
+}
+
+// 序列化后的值是否超过 MaxCacheBytes
+func (c *ModelFunc) oversized(data []byte) bool {
+	return c.MaxCacheBytes > 0 && len(data) > c.MaxCacheBytes
+}
+
+// 值超过 MaxCacheBytes 时记录指标并返回 true, 调用方跳过写入 redis
+func (c *ModelFunc) cacheTooLarge(key string, data []byte) bool {
+	if !c.oversized(data) {
+		return false
+	}
+	c.metrics().ObserveError("MaxCacheBytes", fmt.Errorf("%w: %s %d 字节", ErrCacheValueTooLarge, key, len(data)))
+	c.logger().Debug("cache value too large, skipped", "key", key, "bytes", len(data), "max", c.MaxCacheBytes)
+	return true
+}
+
+// 缓存数据无法反序列化时删除该key, 调用方当作未命中重新查询数据库
+func (c *ModelFunc) healCache(ctx context.Context, key string, err error) {
+	c.metrics().ObserveError("SelfHealCache", err)
+	c.logger().Debug("corrupted cache deleted", "key", key, "err", err)
+	if err = c.delKeys(ctx, key); err != nil {
+		c.logger().Debug("delete corrupted cache failed", "key", key, "err", err)
+	}
+}
+
+// 命中缓存后延长过期时间, 失败时只记录日志, 不影响本次查询
+func (c *ModelFunc) slideExpire(ctx context.Context, model interface{}, key string) {
+	if err := c.RedisClient.Expire(ctx, key, c.cacheExpire(model)).Err(); err != nil {
+		c.logger().Debug("sliding expire failed", "key", key, "err", err)
+	}
+}
+
+// 写入空值缓存
+func (c *ModelFunc) updateNilCache(ctx context.Context, model interface{}, id interface{}) error {
+	key := c.cacheKey(model, id)
+	c.removeLocal(key)
+	return c.retry(ctx, func() error {
+		return c.RedisClient.Set(ctx, key, nilCacheValue, c.NegativeExpire).Err()
+	})
+}
+
+// 新增记录后删除该id的空值缓存, 否则新记录在 NegativeExpire 过期之前仍然查询不到
+func (c *ModelFunc) delNilCache(ctx context.Context, model interface{}, id uint64) error {
+	if !c.UseCache || c.NegativeExpire <= 0 || id == 0 {
+		return nil
+	}
+	return c.delKeys(ctx, c.cacheKey(model, id))
+}
+
+func (c *ModelFunc) getCache(ctx context.Context, model interface{}, id interface{}) error {
+	return c.loadCache(ctx, c.cacheKey(model, id), model)
+}
+
+// 读取指定的缓存key并反序列化到模型
+func (c *ModelFunc) loadCache(ctx context.Context, key string, model interface{}) error {
+	var res string
+	err := c.retry(ctx, func() (err error) {
+		res, err = c.RedisClient.Get(ctx, key).Result()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if res == nilCacheValue {
+		return gorm.ErrRecordNotFound
+	}
+
+	if err = c.decode([]byte(res), model); err != nil && c.SelfHealCache {
+		c.healCache(ctx, key, err)
+		return redis.Nil
+	}
+	return err
+}
+
+func (c *ModelFunc) updateByIdM(ctx context.Context, model interface{}, id interface{}, requireRows bool, conds ...interface{}) (int64, error) {
+	defer c.observeDB("UpdateById", time.Now())
+	if c.VersionColumn != "" {
+		return c.updateByIdVersionM(ctx, model, id, requireRows, conds...)
+	}
+	tx := c.db(ctx).Where(c.primaryKey()+" = ?", id).Scopes(whereConds(conds)).Updates(model)
+	if tx.Error == nil && tx.RowsAffected == 0 && requireRows {
+		tx.Error = ErrNoRowsAffected
+	}
+	return tx.RowsAffected, tx.Error
+}
+
+// 附加的 WHERE 条件, 用于 UpdateByIdIf 和 DeleteByCondition
+func whereConds(conds []interface{}) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if len(conds) > 0 {
+			tx = tx.Where(conds[0], conds[1:]...)
+		}
+		return tx
+	}
+}
+
+// 乐观锁更新, 使用模型中的版本号作为条件, 更新时版本号加1, 没有更新到记录时返回 ErrOptimisticLock, requireRows 为 true 时返回 ErrNoRowsAffected
+func (c *ModelFunc) updateByIdVersionM(ctx context.Context, model interface{}, id interface{}, requireRows bool, conds ...interface{}) (int64, error) {
+	field, err := c.lookUpField(model, c.VersionColumn)
+	if err != nil {
+		return 0, err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(model))
+	value, _ := field.ValueOf(ctx, rv)
+	version, err := cast.ToInt64E(value)
+	if err != nil {
+		return 0, err
+	}
+	if err = field.Set(ctx, rv, version+1); err != nil {
+		return 0, err
+	}
+
+	tx := c.db(ctx).Where(c.primaryKey()+" = ?", id).Where(field.DBName+" = ?", version).Scopes(whereConds(conds)).Updates(model)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		if requireRows {
+			tx.Error = ErrNoRowsAffected
+		} else {
+			tx.Error = ErrOptimisticLock
+		}
+	}
+	if tx.Error != nil {
+		// 更新失败时恢复模型中的版本号
+		_ = field.Set(ctx, rv, version)
+	}
+	return tx.RowsAffected, tx.Error
+}
+
+func (c *ModelFunc) updateByIdR(ctx context.Context, model interface{}, id interface{}, requireRows bool, conds ...interface{}) (int64, error) {
+	stale := c.staleKeys(ctx, model, id)
+
+	// 更新
+	rows, err := c.updateByIdM(ctx, model, id, requireRows, conds...)
+	if err != nil {
+		return rows, err
+	}
+
+	if c.CacheWriteThrough {
+		return rows, c.cacheErr("UpdateById", c.writeThrough(ctx, model, id, stale...))
+	}
+
+	// 清除缓存和link缓存, 包括更新前的link缓存
+	return rows, c.cacheErr("UpdateById", c.invalidate(ctx, model, id, stale...))
+}
+
+func (c *ModelFunc) updateColumnsM(ctx context.Context, model interface{}, id interface{}, fields map[string]interface{}) error {
+	defer c.observeDB("UpdateColumns", time.Now())
+	return c.db(ctx).Model(model).Where(c.primaryKey()+" = ?", id).Updates(fields).Error
+}
+
+func (c *ModelFunc) updateColumnsR(ctx context.Context, model interface{}, id interface{}, fields map[string]interface{}) error {
+	stale := c.staleKeys(ctx, model, id)
+	if err := c.updateColumnsM(ctx, model, id, fields); err != nil {
+		return err
+	}
+
+	// 清除缓存和link缓存, 包括更新前的link缓存
+	return c.cacheErr("UpdateColumns", c.invalidate(ctx, model, id, stale...))
+}
+
+func (c *ModelFunc) upsertByIdM(ctx context.Context, model interface{}, id uint64, updateColumns []string) error {
+	defer c.observeDB("UpsertById", time.Now())
+	if err := c.setModelId(ctx, model, id); err != nil {
+		return err
+	}
+
+	onConflict := clause.OnConflict{Columns: []clause.Column{{Name: c.primaryKey()}}}
+	if len(updateColumns) == 0 {
+		onConflict.UpdateAll = true
+	} else {
+		onConflict.DoUpdates = clause.AssignmentColumns(updateColumns)
+	}
+	return c.db(ctx).Clauses(onConflict).Create(model).Error
+}
+
+func (c *ModelFunc) upsertByIdR(ctx context.Context, model interface{}, id uint64, updateColumns []string) error {
+	stale := c.staleKeys(ctx, model, id)
+	if err := c.upsertByIdM(ctx, model, id, updateColumns); err != nil {
+		return err
+	}
+
+	// 清除缓存和link缓存, 包括更新前的link缓存, 新增时同时清除空值缓存
+	return c.cacheErr("UpsertById", c.invalidate(ctx, model, id, stale...))
+}
+
+// 检查 Save 的模型是否完整, 主键需要与 id 一致, 自动写入创建时间的字段不能为零值
+func (c *ModelFunc) checkSave(ctx context.Context, model interface{}, id interface{}) error {
+	stmt := &gorm.Statement{DB: c.MysqlCient}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(model))
+
+	pk := stmt.Schema.LookUpField(c.primaryKey())
+	if pk == nil {
+		return fmt.Errorf("%w: 模型 %s 缺少主键 %s", ErrMissingField, stmt.Schema.Name, c.primaryKey())
+	}
+	value, isZero := pk.ValueOf(ctx, rv)
+	if isZero || keyString(value) != keyString(id) {
+		return fmt.Errorf("%w: 主键 %s 为 %v, 与 id %v 不一致", ErrStrictSave, pk.DBName, value, id)
+	}
+
+	for _, field := range stmt.Schema.Fields {
+		if field.AutoCreateTime == 0 {
+			continue
+		}
+		if _, isZero = field.ValueOf(ctx, rv); isZero {
+			return fmt.Errorf("%w: 字段 %s 为零值", ErrStrictSave, field.DBName)
+		}
+	}
+	return nil
+}
+
+func (c *ModelFunc) saveByIdM(ctx context.Context, model interface{}, id interface{}) error {
+	defer c.observeDB("SaveById", time.Now())
+	return c.db(ctx).Where(c.primaryKey()+" = ?", id).Save(model).Error
+}
+
+func (c *ModelFunc) saveByIdR(ctx context.Context, model interface{}, id interface{}) error {
+	stale := c.staleKeys(ctx, model, id)
+
+	// 更新
+	if err := c.saveByIdM(ctx, model, id); err != nil {
+		return err
+	}
+
+	if c.CacheWriteThrough {
+		return c.cacheErr("SaveById", c.writeThrough(ctx, model, id, stale...))
+	}
+
+	// 清除缓存和link缓存, 包括更新前的link缓存
+	return c.cacheErr("SaveById", c.invalidate(ctx, model, id, stale...))
+}
+
+func (c *ModelFunc) firstByIdM(ctx context.Context, model interface{}, id interface{}) error {
+	defer c.observeDB("FirstById", time.Now())
+	return c.db(ctx).Where(c.primaryKey()+" = ?", id).First(model).Error
+}
+
+func (c *ModelFunc) firstByIdSelectM(ctx context.Context, model interface{}, id interface{}, columns []string) error {
+	defer c.observeDB("FirstByIdSelect", time.Now())
+	return c.db(ctx).Select(columns).Where(c.primaryKey()+" = ?", id).First(model).Error
+}
+
+func (c *ModelFunc) firstByIdSelectR(ctx context.Context, model interface{}, id interface{}, columns []string) error {
+	key, field := c.selectKey(model, id), selectField(columns)
+	var res string
+	err := c.retry(ctx, func() (err error) {
+		res, err = c.RedisClient.HGet(ctx, key, field).Result()
+		return err
+	})
+	if err == nil {
+		if err = c.decode([]byte(res), model); err == nil || !c.SelfHealCache {
+			c.observeCache("FirstByIdSelect", true)
+			c.logger().Debug("cache hit", "key", key, "field", field)
+			return err
+		}
+		c.healCache(ctx, key, err)
+	} else if !ErrIsRedisNil(err) && c.cacheErr("FirstByIdSelect", err) != nil {
+		return err
+	}
+	c.observeCache("FirstByIdSelect", false)
+	c.logger().Debug("cache miss, querying db", "key", key, "field", field)
+
+	if err = c.firstByIdSelectM(ctx, model, id, columns); err != nil {
+		return err
+	}
+	marshalData, err := c.encode(model)
+	if err != nil {
+		return err
+	}
+	if c.cacheTooLarge(key, marshalData) {
+		return nil
+	}
+	err = c.retry(ctx, func() error {
+		pipe := c.RedisClient.Pipeline()
+		pipe.HSet(ctx, key, field, string(marshalData))
+		pipe.Expire(ctx, key, c.cacheExpire(model))
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	return c.cacheErr("FirstByIdSelect", err)
+}
+
+func (c *ModelFunc) firstByIdScopedM(ctx context.Context, model interface{}, id interface{}, scopes ...func(*gorm.DB) *gorm.DB) error {
+	defer c.observeDB("FirstByIdScoped", time.Now())
+	return c.db(ctx).Scopes(scopes...).Where(c.primaryKey()+" = ?", id).First(model).Error
+}
+
+func (c *ModelFunc) firstByIdPreloadM(ctx context.Context, model interface{}, id interface{}, preloads ...string) error {
+	defer c.observeDB("FirstByIdPreload", time.Now())
+	db := c.db(ctx)
+	for _, preload := range preloads {
+		db = db.Preload(preload)
+	}
+	return db.Where(c.primaryKey()+" = ?", id).First(model).Error
+}
+
+func (c *ModelFunc) firstByIdR(ctx context.Context, model interface{}, id interface{}) (hit bool, err error) {
+	key := c.cacheKey(model, id)
+	if c.LocalCache != nil {
+		if data, exist := c.LocalCache.get(key); exist {
+			c.observeCache("FirstById", true)
+			c.logger().Debug("local cache hit", "key", key)
+			return true, c.codec().Unmarshal(data, model)
+		}
+	}
+
+	if err = c.getCache(ctx, model, id); err == nil {
+		c.observeCache("FirstById", true)
+		c.logger().Debug("cache hit", "key", key)
+		c.setLocal(key, model)
+		if c.SlidingExpire {
+			c.slideExpire(ctx, model, key)
+		}
+		return true, nil
+	} else if ErrIsGormNil(err) {
+		// 命中空值缓存
+		c.observeCache("FirstById", true)
+		c.logger().Debug("negative cache hit", "key", key)
+		return true, err
+	} else if !ErrIsRedisNil(err) && c.cacheErr("FirstById", err) != nil {
+		return false, err
+	}
+	c.observeCache("FirstById", false)
+	c.logger().Debug("cache miss, querying db", "key", key)
+
+	// 同一个key同时只有一个协程查询数据库，其余协程共享结果，错误不缓存
+	data, err, shared := c.group.Do("id|"+key, func() (interface{}, error) {
+		if err := c.firstByIdM(ctx, model, id); err != nil {
+			if ErrIsGormNil(err) && c.NegativeExpire > 0 {
+				c.logger().Debug("record not found, writing negative cache", "key", key)
+				if err := c.cacheErr("FirstById", c.updateNilCache(ctx, model, id)); err != nil {
+					return nil, err
+				}
+			}
+			return nil, err
+		}
+
+		if err := c.cacheErr("FirstById", c.updateCache(ctx, model, id)); err != nil {
+			return nil, err
+		}
+
+		return c.codec().Marshal(c.cacheValue(model))
+	})
+	if err != nil {
+		return false, err
+	}
+	if shared {
+		c.logger().Debug("shared db result", "key", key)
+		if err = c.codec().Unmarshal(data.([]byte), model); err != nil {
+			return false, err
+		}
+	}
+	// 超过 MaxCacheBytes 的值 redis 中没有缓存, 本地缓存同样不保存
+	if c.LocalCache != nil && !c.oversized(data.([]byte)) {
+		c.LocalCache.set(key, data.([]byte))
+	}
+
+	return false, nil
+}
+
+func (c *ModelFunc) firstByUniqueM(ctx context.Context, model interface{}, column string, value interface{}) error {
+	defer c.observeDB("FirstByUnique", time.Now())
+	return c.db(ctx).Where(column+" = ?", value).First(model).Error
+}
+
+func (c *ModelFunc) firstByUniqueR(ctx context.Context, model interface{}, column string, value interface{}) error {
+	key := c.uniqueKey(model, column, value)
+	err := c.loadCache(ctx, key, model)
+	if err == nil {
+		c.observeCache("FirstByUnique", true)
+		c.logger().Debug("cache hit", "key", key)
+		return nil
+	} else if !ErrIsRedisNil(err) && c.cacheErr("FirstByUnique", err) != nil {
+		return err
+	}
+	c.observeCache("FirstByUnique", false)
+	c.logger().Debug("cache miss, querying db", "key", key)
+
+	if err = c.firstByUniqueM(ctx, model, column, value); err != nil {
+		return err
+	}
+
+	return c.cacheErr("FirstByUnique", c.setCache(ctx, key, model))
+}
+
+func (c *ModelFunc) existsM(ctx context.Context, model interface{}, id uint64) (bool, error) {
+	defer c.observeDB("Exists", time.Now())
+	var one int
+	tx := c.db(ctx).Model(model).Select("1").Where(c.primaryKey()+" = ?", id).Limit(1).Scan(&one)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected > 0, nil
+}
+
+func (c *ModelFunc) existsR(ctx context.Context, model interface{}, id uint64) (bool, error) {
+	var res string
+	err := c.retry(ctx, func() (err error) {
+		res, err = c.RedisClient.Get(ctx, c.cacheKey(model, id)).Result()
+		return err
+	})
+	if err != nil && !ErrIsRedisNil(err) && c.cacheErr("Exists", err) != nil {
+		return false, err
+	} else if err != nil {
+		c.observeCache("Exists", false)
+		return c.existsM(ctx, model, id)
+	}
+
+	c.observeCache("Exists", true)
+	return res != nilCacheValue, nil
+}
+
+func (c *ModelFunc) firstByIdsM(ctx context.Context, models interface{}, ids []uint64) error {
+	defer c.observeDB("FirstByIds", time.Now())
+	if err := c.db(ctx).Where(c.primaryKey()+" IN ?", ids).Find(models).Error; err != nil {
+		return err
+	}
+
+	list := reflect.ValueOf(models).Elem()
+	rows := make(map[uint64]reflect.Value, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		id, err := c.modelId(ctx, modelPtr(list.Index(i)))
+		if err != nil {
+			return err
+		}
+		rows[id] = list.Index(i)
+	}
+
+	// 按 ids 顺序排列
+	list.Set(sortByIds(list.Type(), ids, rows))
+	return nil
+}
+
+func (c *ModelFunc) firstByIdsR(ctx context.Context, models interface{}, ids []uint64) error {
+	list := reflect.ValueOf(models).Elem()
+	rows := make(map[uint64]reflect.Value, len(ids))
+
+	// 批量读取缓存
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = c.cacheKey(models, id)
+	}
+	var values []interface{}
+	err := c.retry(ctx, func() (err error) {
+		values, err = c.mget(ctx, keys...)
+		return err
+	})
+	if err != nil {
+		if c.cacheErr("FirstByIds", err) != nil {
+			return err
+		}
+		// 全部当作未命中
+		values = make([]interface{}, len(ids))
+	}
+
+	misses := make([]uint64, 0, len(ids))
+	for i, value := range values {
+		res, ok := value.(string)
+		c.observeCache("FirstByIds", ok)
+		if !ok {
+			misses = append(misses, ids[i])
+			continue
+		} else if res == nilCacheValue {
+			continue
+		}
+		row := newModel(list.Type().Elem())
+		if err = c.decode([]byte(res), modelPtr(row)); err != nil {
+			if !c.SelfHealCache {
+				return err
+			}
+			c.healCache(ctx, keys[i], err)
+			misses = append(misses, ids[i])
+			continue
+		}
+		rows[ids[i]] = row
+	}
+	c.logger().Debug("batch cache lookup", "ids", len(ids), "misses", misses)
+
+	// 查询未命中的记录，并回填缓存
+	if len(misses) > 0 {
+		found := reflect.New(list.Type())
+		if err = c.firstByIdsM(ctx, found.Interface(), misses); err != nil {
+			return err
+		}
+		for i := 0; i < found.Elem().Len(); i++ {
+			row := found.Elem().Index(i)
+			id, err := c.modelId(ctx, modelPtr(row))
+			if err != nil {
+				return err
+			}
+			if err = c.cacheErr("FirstByIds", c.updateCache(ctx, modelPtr(row), id)); err != nil {
+				return err
+			}
+			rows[id] = row
+		}
+
+		// 数据库中也不存在的记录，写入空值缓存
+		if c.NegativeExpire > 0 {
+			for _, id := range misses {
+				if _, exist := rows[id]; exist {
+					continue
+				}
+				if err = c.cacheErr("FirstByIds", c.updateNilCache(ctx, models, id)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// 按 ids 顺序排列
+	list.Set(sortByIds(list.Type(), ids, rows))
+	return nil
+}
+
+func (c *ModelFunc) firstByIdFilterSoftDelM(ctx context.Context, model interface{}, id interface{}) error {
+	defer c.observeDB("FirstByIdSD", time.Now())
+	return c.db(ctx).Where(c.primaryKey()+" = ?", id).Scopes(c.notDeleted).First(model).Error
+}
+
+func (c *ModelFunc) firstByIdFilterSoftDelR(ctx context.Context, model interface{}, id interface{}) error {
+	key := c.sdKey(model, id)
+	err := c.loadCache(ctx, key, model)
+	if err == nil || ErrIsGormNil(err) {
+		c.observeCache("FirstByIdSD", true)
+		c.logger().Debug("cache hit", "key", key)
+		return err
+	} else if !ErrIsRedisNil(err) && c.cacheErr("FirstByIdSD", err) != nil {
+		return err
+	}
+	c.observeCache("FirstByIdSD", false)
+	c.logger().Debug("cache miss, querying db", "key", key)
+
+	if err = c.firstByIdFilterSoftDelM(ctx, model, id); err != nil {
+		return err
+	}
+
+	return c.cacheErr("FirstByIdSD", c.setCache(ctx, key, model))
+}
+
+func (c *ModelFunc) deleteByIdM(ctx context.Context, model interface{}, id interface{}) (int64, error) {
+	defer c.observeDB("DeleteById", time.Now())
+	tx := c.db(ctx).Where(c.primaryKey()+" = ?", id).Delete(model)
+	return tx.RowsAffected, tx.Error
+}
+
+func (c *ModelFunc) deleteByIdR(ctx context.Context, model interface{}, id interface{}) (int64, error) {
+	// 传入的模型通常是空的, 使用数据库中的原记录生成link缓存和唯一字段缓存的key
+	stale := c.staleKeys(ctx, model, id)
+	rows, err := c.deleteByIdM(ctx, model, id)
+	if err != nil {
+		return rows, err
+	}
+
+	// 清除缓存和link缓存
+	return rows, c.cacheErr("DeleteById", c.invalidate(ctx, model, id, stale...))
+}
+
+func (c *ModelFunc) hardDeleteByIdM(ctx context.Context, model interface{}, id interface{}) error {
+	defer c.observeDB("HardDeleteById", time.Now())
+	return c.db(ctx).Unscoped().Where(c.primaryKey()+" = ?", id).Delete(model).Error
+}
+
+func (c *ModelFunc) hardDeleteByIdR(ctx context.Context, model interface{}, id interface{}) error {
+	stale := c.staleKeys(ctx, model, id)
+	if err := c.hardDeleteByIdM(ctx, model, id); err != nil {
+		return err
+	}
+
+	// 清除缓存和link缓存
+	return c.cacheErr("HardDeleteById", c.invalidate(ctx, model, id, stale...))
+}
+
+func (c *ModelFunc) deleteByConditionM(ctx context.Context, model interface{}, conds ...interface{}) (int64, error) {
+	defer c.observeDB("DeleteByCondition", time.Now())
+	tx := c.db(ctx).Where(conds[0], conds[1:]...).Delete(model)
+	return tx.RowsAffected, tx.Error
+}
+
+func (c *ModelFunc) deleteByConditionR(ctx context.Context, model interface{}, conds ...interface{}) (int64, error) {
+	// 删除前查询记录, 用于清除id缓存、link缓存和唯一字段缓存
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+	start := time.Now()
+	err := c.db(ctx).Where(conds[0], conds[1:]...).Find(rows.Interface()).Error
+	c.observeDB("DeleteByCondition", start)
+	if err != nil {
+		return 0, err
+	}
+	if rows.Elem().Len() == 0 {
+		return 0, nil
+	}
+
+	ids := make([]uint64, 0, rows.Elem().Len())
+	keys := make([]string, 0, rows.Elem().Len())
+	for i := 0; i < rows.Elem().Len(); i++ {
+		row := rows.Elem().Index(i).Interface()
+		id, err := c.modelId(ctx, row)
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+		keys = append(keys, c.invalidateKeys(ctx, row, id)...)
+	}
+
+	affected, err := c.deleteByIdsM(ctx, model, ids, conds...)
+	if err != nil {
+		return affected, err
+	}
+	return affected, c.cacheErr("DeleteByCondition", c.delKeys(ctx, keys...))
+}
+
+// 按id删除, 同时使用 conds 作为条件, 防止删除查询之后被修改为不再匹配的记录
+func (c *ModelFunc) deleteByIdsM(ctx context.Context, model interface{}, ids []uint64, conds ...interface{}) (int64, error) {
+	defer c.observeDB("DeleteByIds", time.Now())
+	tx := c.db(ctx).Scopes(whereConds(conds)).Where(c.primaryKey()+" IN ?", ids).Delete(model)
+	return tx.RowsAffected, tx.Error
+}
+
+func (c *ModelFunc) softDeleteByIdM(ctx context.Context, model interface{}, id interface{}) (int64, error) {
+	defer c.observeDB("SoftDeleteById", time.Now())
+	tx := c.db(ctx).Model(model).Where(c.primaryKey()+" = ?", id).Updates(map[string]interface{}{c.softDeleteColumn(): c.now()})
+	return tx.RowsAffected, tx.Error
+}
+
+func (c *ModelFunc) softDeleteByIdR(ctx context.Context, model interface{}, id interface{}) (int64, error) {
+	stale := c.staleKeys(ctx, model, id)
+	rows, err := c.softDeleteByIdM(ctx, model, id)
+	if err != nil {
+		return rows, err
+	}
+
+	// 清除缓存和link缓存
+	return rows, c.cacheErr("SoftDeleteById", c.invalidate(ctx, model, id, stale...))
+}
+
+func (c *ModelFunc) softDeleteByIdsM(ctx context.Context, model interface{}, ids []uint64) error {
+	defer c.observeDB("SoftDeleteByIds", time.Now())
+	return c.db(ctx).Model(model).Where(c.primaryKey()+" IN ?", ids).Updates(map[string]interface{}{c.softDeleteColumn(): c.now()}).Error
+}
+
+func (c *ModelFunc) softDeleteByIdsR(ctx context.Context, model interface{}, ids []uint64) error {
+	keys := make([]string, 0, len(ids))
+	if len(c.LinkMap) > 0 || len(c.UniqueColumns) > 0 {
+		// 软删前查询记录, 用于清除link缓存和唯一字段缓存
+		rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+		if err := c.firstByIdsM(ctx, rows.Interface(), ids); err != nil {
+			return err
+		}
+		for i := 0; i < rows.Elem().Len(); i++ {
+			row := rows.Elem().Index(i).Interface()
+			id, err := c.modelId(ctx, row)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, c.invalidateKeys(ctx, row, id)...)
+		}
+	}
+
+	if err := c.softDeleteByIdsM(ctx, model, ids); err != nil {
+		return err
+	}
+
+	// 使用 pipeline 清除所有id缓存
+	for _, id := range ids {
+		keys = append(keys, c.cacheKey(model, id), c.selectKey(model, id), c.sdKey(model, id))
+	}
+	return c.cacheErr("SoftDeleteByIds", c.delKeys(ctx, keys...))
+}
+
+func (c *ModelFunc) restoreByIdM(ctx context.Context, model interface{}, id interface{}) error {
+	defer c.observeDB("RestoreById", time.Now())
+	return c.db(ctx).Unscoped().Model(model).Where(c.primaryKey()+" = ?", id).Updates(map[string]interface{}{c.softDeleteColumn(): c.activeValue()}).Error
+}
+
+func (c *ModelFunc) touchM(ctx context.Context, model interface{}, id interface{}) error {
+	defer c.observeDB("Touch", time.Now())
+	return c.db(ctx).Model(model).Where(c.primaryKey()+" = ?", id).UpdateColumn(c.updatedAtColumn(), c.now()).Error
+}
+
+func (c *ModelFunc) restoreByIdR(ctx context.Context, model interface{}, id interface{}) error {
+	stale := c.staleKeys(ctx, model, id)
+	if err := c.restoreByIdM(ctx, model, id); err != nil {
+		return err
+	}
+
+	// 清除缓存和link缓存
+	return c.cacheErr("RestoreById", c.invalidate(ctx, model, id, stale...))
+}
+
+func (c *ModelFunc) linkKey(linkType, field string) string {
+	if c.HashLinkKeys && len(field) > c.HashLinkKeyMinLen {
+		sum := sha256.Sum256([]byte(field))
+		field = hex.EncodeToString(sum[:])
+	}
+	if c.LinkKeyFunc != nil {
+		return c.LinkKeyFunc(c.RedisPrefix, linkType, field)
+	}
+	return fmt.Sprintf("%s%s:%s", c.RedisPrefix, linkType, field)
+}
+
+// link 缓存整条记录的key
+func (c *ModelFunc) linkRowKey(linkType, field string) string {
+	return c.linkKey(linkType, field) + ":row"
+}
+
+// link 缓存过期间隔, 依次使用 LinkExpireMap、LinkExpire、默认7天
+func (c *ModelFunc) linkExpire(linkType string) time.Duration {
+	if expire, exist := c.LinkExpireMap[linkType]; exist {
+		return expire
+	}
+	c.expireMu.RLock()
+	defer c.expireMu.RUnlock()
+	if c.LinkExpire != 0 {
+		return c.LinkExpire
+	}
+	return time.Hour * 24 * 7
+}
+
+// 运行中修改缓存过期间隔, 与正在执行的请求并发安全, 之后写入的缓存使用新的过期间隔
+func (c *ModelFunc) SetExpire(expire time.Duration) {
+	c.expireMu.Lock()
+	defer c.expireMu.Unlock()
+	c.Expire = expire
+}
+
+// 运行中修改 link 缓存过期间隔, 与正在执行的请求并发安全
+func (c *ModelFunc) SetLinkExpire(expire time.Duration) {
+	c.expireMu.Lock()
+	defer c.expireMu.Unlock()
+	c.LinkExpire = expire
+}
+
+// 解析link对应的id, 缓存中不存在时使用 find 查询并写入缓存
+func (c *ModelFunc) resolveLink(ctx context.Context, linkType, field string, find func() (uint64, error)) (uint64, error) {
+	// 没有设置 RedisClient 或者 ctx 设置了 WithNoCache 时不缓存link
+	if !c.linkCache(ctx) {
+		return find()
+	}
+
+	id, _ := c.getLink(ctx, linkType, field)
+	if cast.ToUint64(id) > 0 {
+		c.logger().Debug("link cache hit", "key", c.linkKey(linkType, field), "id", id)
+		c.observeLink(linkType, LinkHit)
+		return cast.ToUint64(id), nil
+	}
+	c.logger().Debug("link cache miss, querying db", "key", c.linkKey(linkType, field))
+
+	// 同一个link同时只有一个协程查询数据库, 其余协程共享结果
+	data, err, _ := c.group.Do("link|"+c.linkKey(linkType, field), func() (interface{}, error) {
+		idInt, err := find()
+		if err != nil {
+			return uint64(0), err
+		}
+		if idInt > 0 {
+			if err = c.createLink(ctx, idInt, linkType, field); err != nil {
+				return uint64(0), err
+			}
+		}
+		return idInt, nil
+	})
+	if err == nil {
+		if data.(uint64) > 0 {
+			c.observeLink(linkType, LinkMissResolved)
+		} else {
+			c.observeLink(linkType, LinkMissUnresolved)
+		}
+	}
+	return data.(uint64), err
+}
+
+// 批量解析link对应的id, 使用 MGET 读取缓存, 未命中的使用 finder 查询并批量写入缓存, 不存在对应记录的 field 跳过
+func (c *ModelFunc) resolveLinks(ctx context.Context, linkType string, fields []string, finder LinkFinder) ([]uint64, error) {
+	ids := make([]uint64, 0, len(fields))
+	if len(fields) == 0 {
+		return ids, nil
+	}
+
+	// 没有设置 RedisClient 或者 ctx 设置了 WithNoCache 时不缓存link
+	linkCache := c.linkCache(ctx)
+	values := make([]interface{}, len(fields))
+	keys := make([]string, len(fields))
+	if linkCache {
+		for i, field := range fields {
+			keys[i] = c.linkKey(linkType, field)
+		}
+		err := c.retry(ctx, func() (err error) {
+			values, err = c.mget(ctx, keys...)
+			return err
+		})
+		if err = c.cacheErr("FirstByLinks", err); err != nil {
+			return nil, err
+		}
+		if values == nil {
+			values = make([]interface{}, len(fields))
+		}
+	}
+
+	var pipe redis.Pipeliner
+	if linkCache {
+		pipe = c.RedisClient.Pipeline()
+	}
+	for i, field := range fields {
+		if id := cast.ToUint64(values[i]); id > 0 {
+			c.observeLink(linkType, LinkHit)
+			ids = append(ids, id)
+			continue
+		}
+		if field == "" {
+			continue
+		}
+		id, err := finder.Find(ctx, c.db(ctx), field)
+		if err != nil {
+			return nil, err
+		}
+		if id == 0 {
+			if linkCache {
+				c.observeLink(linkType, LinkMissUnresolved)
+			}
+			continue
+		}
+		ids = append(ids, id)
+		if pipe != nil {
+			c.observeLink(linkType, LinkMissResolved)
+			pipe.SetNX(ctx, keys[i], id, c.linkExpire(linkType))
+		}
+	}
+	c.logger().Debug("batch link lookup", "link_type", linkType, "fields", len(fields), "ids", len(ids))
+
+	if pipe != nil && pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); c.cacheErr("FirstByLinks", err) != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func (c *ModelFunc) getLink(ctx context.Context, linkType, field string) (string, error) {
+	if field == "" {
+		return "", fmt.Errorf("%w: getLink 缺少参数 field", ErrMissingField)
+	}
+	var id string
+	err := c.retry(ctx, func() (err error) {
+		id, err = c.RedisClient.Get(ctx, c.linkKey(linkType, field)).Result()
+		return err
+	})
+	return id, err
+}
+
+func (c *ModelFunc) createLink(ctx context.Context, id uint64, linkType, field string) error {
+	if id == 0 {
+		return fmt.Errorf("%w: createLink 缺少参数 id", ErrMissingId)
+	} else if field == "" {
+		return fmt.Errorf("%w: createLink 缺少参数 field", ErrMissingField)
+	}
+	// 使用 SETNX 只有第一次写入生效, 多个实例同时写入不同的id时不会互相覆盖
+	return c.retry(ctx, func() error {
+		return c.RedisClient.SetNX(ctx, c.linkKey(linkType, field), id, c.linkExpire(linkType)).Err()
+	})
+}
+
+func (c *ModelFunc) delLink(ctx context.Context, linkType, field string) error {
+	if field == "" {
+		return fmt.Errorf("%w: delLink 缺少参数 field", ErrMissingField)
+	}
+	c.logger().Debug("invalidated link", "key", c.linkKey(linkType, field))
+	return c.delKeys(ctx, c.linkKey(linkType, field), c.linkRowKey(linkType, field))
+}
+
+func (c *ModelFunc) hook(hookMethod string, ctx context.Context, model interface{}, id interface{}) error {
+	// 优先使用钩子接口
+	if fn := interfaceHook(hookMethod, model); fn != nil {
+		return c.callHookFn(hookMethod, ctx, fn)
+	}
+	a := reflect.ValueOf(model)
+	m := a.MethodByName(hookMethod)
+	if !m.IsValid() {
+		return nil
+	}
+	params, err := c.hookParams(hookMethod, ctx, m)
+	if err != nil {
+		return err
+	}
+	// 钩子声明了第四个参数时传入id
+	if m.Type().NumIn() == 4 {
+		idValue, ok := hookIdValue(id, m.Type().In(3))
+		if !ok {
+			return fmt.Errorf("钩子 %s 的第四个参数必须是 id", hookMethod)
+		}
+		params = append(params, idValue)
+	}
+	return callHook(hookMethod, m, params)
+}
+
+// 批量操作的钩子, 每次批量操作只执行一次, 钩子声明在模型上, 第四个参数接收 arg
+// CreateBatch 的 arg 为传入的切片, SoftDeleteByIds 的 arg 为 ids
+func (c *ModelFunc) batchHook(hookMethod string, ctx context.Context, model interface{}, arg interface{}) error {
+	if fn := interfaceBatchHook(hookMethod, model, arg); fn != nil {
+		return c.callHookFn(hookMethod, ctx, fn)
+	}
+	m := reflect.ValueOf(model).MethodByName(hookMethod)
+	if !m.IsValid() {
+		return nil
+	}
+	params, err := c.hookParams(hookMethod, ctx, m)
+	if err != nil {
+		return err
+	}
+	if m.Type().NumIn() == 4 {
+		argValue := reflect.ValueOf(arg)
+		if !argValue.Type().AssignableTo(m.Type().In(3)) {
+			return fmt.Errorf("钩子 %s 的第四个参数类型 %s 与 %s 不一致", hookMethod, m.Type().In(3), argValue.Type())
+		}
+		params = append(params, argValue)
+	}
+	return callHook(hookMethod, m, params)
+}
+
+// 钩子的前三个参数 ctx、db、rdc
+func (c *ModelFunc) hookParams(hookMethod string, ctx context.Context, m reflect.Value) ([]reflect.Value, error) {
+	if err := checkHookType(hookMethod, m.Type()); err != nil {
+		return nil, err
+	}
+	params := make([]reflect.Value, 3, 4)
+	params[0] = reflect.ValueOf(ctx)
+	params[1] = reflect.ValueOf(c.hookDB(ctx))
+	params[2] = reflect.Zero(m.Type().In(2))
+	if c.RedisClient != nil {
+		// 钩子的 rdc 参数可以声明为 *redis.Client 或者 redis.UniversalClient
+		rdc := reflect.ValueOf(c.RedisClient)
+		if !rdc.Type().AssignableTo(m.Type().In(2)) {
+			return nil, fmt.Errorf("钩子 %s 的第三个参数类型 %s 与 RedisClient 类型 %s 不一致", hookMethod, m.Type().In(2), rdc.Type())
+		}
+		params[2] = rdc
+	}
+	return params, nil
+}
+
+func callHook(hookMethod string, m reflect.Value, params []reflect.Value) error {
+	values := m.Call(params)
+	switch err := values[0].Interface().(type) {
+	case error:
+		// 包装钩子名, 方便定位是哪个钩子返回的错误
+		return fmt.Errorf("mf hook %s failed: %w", hookMethod, err)
+	default:
+		return nil
+	}
+}
+
+// 将id转换为钩子第四个参数的类型
+func hookIdValue(id interface{}, paramType reflect.Type) (reflect.Value, bool) {
+	idValue := reflect.ValueOf(id)
+	switch {
+	case idValue.Type().AssignableTo(paramType):
+		return idValue, true
+	case paramType.Kind() == reflect.String:
+		return reflect.ValueOf(keyString(id)).Convert(paramType), true
+	case idValue.Kind() != reflect.String && idValue.Type().ConvertibleTo(paramType):
+		return idValue.Convert(paramType), true
+	}
+	return reflect.Value{}, false
+}
+
+// 转义 redis glob 模式中的特殊字符
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// 将任意类型的主键转换为字符串
+func keyString(id interface{}) string {
+	if str, err := cast.ToStringE(id); err == nil {
+		return str
+	}
+	return fmt.Sprint(id)
+}
+
+// 解析模型的主键值
+func (c *ModelFunc) modelId(ctx context.Context, model interface{}) (uint64, error) {
+	field, err := c.lookUpField(model, c.primaryKey())
+	if err != nil {
+		return 0, err
+	}
+	value, _ := field.ValueOf(ctx, reflect.Indirect(reflect.ValueOf(model)))
+	return cast.ToUint64E(value)
+}
+
+// 查找模型中字段名对应的字段
+func (c *ModelFunc) lookUpField(model interface{}, column string) (*schema.Field, error) {
+	stmt := &gorm.Statement{DB: c.MysqlCient}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	field := stmt.Schema.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("%w: 模型 %s 缺少字段 %s", ErrMissingField, stmt.Schema.Name, column)
+	}
+	return field, nil
+}
+
+// 设置模型的主键值
+func (c *ModelFunc) setModelId(ctx context.Context, model interface{}, id uint64) error {
+	field, err := c.lookUpField(model, c.primaryKey())
+	if err != nil {
+		return err
+	}
+	return field.Set(ctx, reflect.Indirect(reflect.ValueOf(model)), id)
+}
+
+// 创建切片元素类型的新值, 元素可以是结构体或结构体指针
+func newModel(elemType reflect.Type) reflect.Value {
+	if elemType.Kind() == reflect.Ptr {
+		return reflect.New(elemType.Elem())
+	}
+	return reflect.New(elemType).Elem()
+}
+
+// 返回可以传给 gorm 和 json 的模型指针
+func modelPtr(row reflect.Value) interface{} {
+	if row.Kind() == reflect.Ptr {
+		return row.Interface()
+	}
+	return row.Addr().Interface()
+}
+
+// 按 ids 顺序组装切片, 不存在的id跳过
+func sortByIds(sliceType reflect.Type, ids []uint64, rows map[uint64]reflect.Value) reflect.Value {
+	list := reflect.MakeSlice(sliceType, 0, len(rows))
+	for _, id := range ids {
+		if row, exist := rows[id]; exist {
+			list = reflect.Append(list, row)
+		}
 	}
+	return list
 }
 
 func ErrIsGormNil(err error) bool {
@@ -372,7 +3215,9 @@ func ErrIsRedisNil(err error) bool {
 	return errors.Is(err, redis.Nil)
 }
 
-// 返回当前时间
+// 返回当前时间, 固定为东八区
+//
+// Deprecated: ModelFunc 使用 Location 配置的时区, 不再使用该方法
 func GetNowTime() time.Time {
 	cstZone := time.FixedZone("CST", 8*3600) // 东八
 	now := time.Now().In(cstZone)
@@ -0,0 +1,1203 @@
+package mf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+	"gorm.io/gorm"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type member struct {
+	Id        uint64 `gorm:"primaryKey"`
+	Name      string
+	Email     string
+	Slug      string
+	DeletedAt time.Time
+}
+
+type memberEmailFinder struct{}
+
+func (memberEmailFinder) Find(ctx context.Context, db *gorm.DB, field string) (uint64, error) {
+	var m member
+	err := db.Where("email = ?", field).First(&m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return m.Id, err
+}
+
+func (memberEmailFinder) FieldValue(model interface{}) string {
+	return model.(*member).Email
+}
+
+func newTestModelFunc(t *testing.T, opts ...Option) (*ModelFunc, *miniredis.Miniredis) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&member{}); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭所有链接后内存数据库才会释放, 否则 -count 多次执行时会读到上一次的数据
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	mr := miniredis.RunT(t)
+	rdc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	opts = append([]Option{
+		WithCache(rdc, "test:", time.Minute),
+		WithLinkMap(map[string]LinkFinder{"email": memberEmailFinder{}}),
+	}, opts...)
+	c, err := New(db, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, mr
+}
+
+func TestFirstByLinkSDSkipsSoftDeleted(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestModelFunc(t)
+	m := &member{Name: "a", Email: "a@x"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	var got member
+	if err := c.FirstByLinkSD(ctx, "email", &got, "a@x"); err != nil || got.Id != m.Id {
+		t.Fatalf("FirstByLinkSD = %v, %+v", err, got)
+	}
+	if err := c.SoftDeleteById(ctx, &member{}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	got = member{}
+	if err := c.FirstByLinkSD(ctx, "email", &got, "a@x"); !ErrIsGormNil(err) {
+		t.Fatalf("软删后 FirstByLinkSD = %v, %+v, 需要返回 gorm.ErrRecordNotFound", err, got)
+	}
+}
+
+func TestDeleteClearsLinkRowCache(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestModelFunc(t, WithLinkCacheRow())
+	deletes := map[string]func(id uint64) error{
+		"DeleteById":     func(id uint64) error { return c.DeleteById(ctx, &member{}, id) },
+		"HardDeleteById": func(id uint64) error { return c.HardDeleteById(ctx, &member{}, id) },
+		"SoftDeleteById": func(id uint64) error { return c.SoftDeleteById(ctx, &member{}, id) },
+	}
+	for name, del := range deletes {
+		m := &member{Name: name, Email: name + "@x"}
+		if err := c.Create(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		var got member
+		if err := c.FirstByLink(ctx, "email", &got, m.Email); err != nil {
+			t.Fatal(err)
+		}
+		if !mr.Exists(c.linkRowKey("email", m.Email)) {
+			t.Fatalf("%s: FirstByLink 没有缓存整条记录", name)
+		}
+		if err := del(m.Id); err != nil {
+			t.Fatal(err)
+		}
+		if mr.Exists(c.linkRowKey("email", m.Email)) || mr.Exists(c.linkKey("email", m.Email)) {
+			t.Fatalf("%s 之后link缓存没有清除", name)
+		}
+	}
+
+	// 删除后不能再通过link查询到记录
+	m := &member{Name: "b", Email: "b@x"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	var got member
+	if err := c.FirstByLink(ctx, "email", &got, "b@x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteById(ctx, &member{}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FirstByLink(ctx, "email", &got, "b@x"); !ErrIsGormNil(err) {
+		t.Fatalf("DeleteById 后 FirstByLink = %v, 需要返回 gorm.ErrRecordNotFound", err)
+	}
+}
+
+func TestRestoreClearsLinkRowCache(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestModelFunc(t, WithLinkCacheRow())
+	m := &member{Name: "a", Email: "r@x"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SoftDeleteById(ctx, &member{}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	var got member
+	if err := c.FirstByLink(ctx, "email", &got, "r@x"); err != nil || got.DeletedAt.IsZero() {
+		t.Fatal(err, got)
+	}
+	if err := c.RestoreById(ctx, &member{}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(c.linkRowKey("email", "r@x")) {
+		t.Fatal("RestoreById 之后link缓存没有清除")
+	}
+}
+
+func TestDeleteClearsUniqueCache(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestModelFunc(t, WithUniqueColumns("slug"))
+	deletes := map[string]func(id uint64) error{
+		"DeleteById":     func(id uint64) error { return c.DeleteById(ctx, &member{}, id) },
+		"HardDeleteById": func(id uint64) error { return c.HardDeleteById(ctx, &member{}, id) },
+	}
+	for name, del := range deletes {
+		m := &member{Name: name, Slug: name}
+		if err := c.Create(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		var got member
+		if err := c.FirstByUnique(ctx, &got, "slug", name); err != nil {
+			t.Fatal(err)
+		}
+		if err := del(m.Id); err != nil {
+			t.Fatal(err)
+		}
+		got = member{}
+		if err := c.FirstByUnique(ctx, &got, "slug", name); !ErrIsGormNil(err) {
+			t.Fatalf("%s 后 FirstByUnique = %v, %+v, 需要返回 gorm.ErrRecordNotFound", name, err, got)
+		}
+	}
+
+	// 软删和恢复后唯一字段缓存中的软删时间需要更新
+	m := &member{Name: "s", Slug: "s"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	var got member
+	if err := c.FirstByUnique(ctx, &got, "slug", "s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SoftDeleteById(ctx, &member{}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FirstByUnique(ctx, &got, "slug", "s"); err != nil || got.DeletedAt.IsZero() {
+		t.Fatalf("SoftDeleteById 后 FirstByUnique = %v, %+v", err, got)
+	}
+	if err := c.RestoreById(ctx, &member{}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	got = member{}
+	if err := c.FirstByUnique(ctx, &got, "slug", "s"); err != nil || !got.DeletedAt.IsZero() {
+		t.Fatalf("RestoreById 后 FirstByUnique = %v, %+v", err, got)
+	}
+}
+
+type account struct {
+	Id     uint64 `gorm:"primaryKey"`
+	Status int
+	Note   string
+}
+
+var accountAfterUpdate func(ctx context.Context) error
+
+func (a *account) MfAfterUpdateById(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient) error {
+	if accountAfterUpdate == nil {
+		return nil
+	}
+	return accountAfterUpdate(ctx)
+}
+
+func TestUpdateByIdIfCondsNotInherited(t *testing.T) {
+	ctx := context.Background()
+	base, _ := newTestModelFunc(t)
+	if err := base.MysqlCient.AutoMigrate(&account{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(base.MysqlCient, WithCache(base.RedisClient, "account:", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &account{Status: 1}
+	b := &account{Status: 5}
+	if err = c.Create(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Create(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// 钩子中的其他更新不能带上 UpdateByIdIf 的条件
+	accountAfterUpdate = func(ctx context.Context) error {
+		accountAfterUpdate = nil
+		return c.UpdateById(ctx, &account{Note: "nested"}, b.Id)
+	}
+	defer func() { accountAfterUpdate = nil }()
+	if err = c.UpdateByIdIf(ctx, &account{Status: 2}, a.Id, "status = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	var got account
+	if err = c.FirstById(ctx, &got, b.Id); err != nil || got.Note != "nested" {
+		t.Fatal(err, got)
+	}
+	if err = c.UpdateByIdIf(ctx, &account{Status: 3}, a.Id, "status = ?", 1); !errors.Is(err, ErrNoRowsAffected) {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryCommentOnEveryQuery(t *testing.T) {
+	ctx := context.Background()
+	var sqls []string
+	c, _ := newTestModelFunc(t, WithQueryComment(), WithOnQuery(func(sql string, d time.Duration, rows int64) {
+		sqls = append(sqls, sql)
+	}))
+	m := &member{Name: "a", Email: "qc@x"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.FirstOrCreate(ctx, &member{Name: "b"}, "name = ?", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Count(ctx, &member{}); err != nil {
+		t.Fatal(err)
+	}
+	var list []*member
+	if err := c.FindByCondition(ctx, &list, "name = ?", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Paginate(ctx, &list, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Exists(ctx, &member{}, 99); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WarmById(ctx, &member{}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WarmByIds(ctx, &list, []uint64{m.Id}); err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range sqls {
+		// sqlite 驱动自定义了 INSERT 子句的构建方式, INSERT 语句不带注释
+		if strings.HasPrefix(sql, "INSERT") {
+			continue
+		}
+		if !strings.HasPrefix(sql, "/* mf:") {
+			t.Errorf("SQL 没有方法名注释: %s", sql)
+		}
+	}
+}
+
+func TestDeleteByConditionKeepsRowsNoLongerMatching(t *testing.T) {
+	ctx := context.Background()
+	changed := false
+	var c *ModelFunc
+	c, _ = newTestModelFunc(t, WithOnQuery(func(sql string, d time.Duration, rows int64) {
+		// 模拟查询之后、删除之前其他请求把记录修改为不再匹配条件
+		if !changed && strings.HasPrefix(sql, "SELECT") {
+			changed = true
+			c.MysqlCient.Exec("UPDATE members SET name = ? WHERE email = ?", "kept", "b@x")
+		}
+	}))
+	for _, m := range []*member{{Name: "old", Email: "a@x"}, {Name: "old", Email: "b@x"}} {
+		if err := c.Create(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := c.DeleteByCondition(ctx, &member{}, "name = ?", "old")
+	if err != nil || rows != 1 {
+		t.Fatal(rows, err)
+	}
+	var left []*member
+	if err = c.MysqlCient.Find(&left).Error; err != nil || len(left) != 1 || left[0].Email != "b@x" {
+		t.Fatal(err, left)
+	}
+}
+
+func TestMaxCacheBytesOnAllWritePaths(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestModelFunc(t, WithMaxCacheBytes(100), WithLocalCache(100, 1<<20, time.Minute))
+	m := &member{Name: strings.Repeat("x", 200), Email: "big@x"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	var got member
+	if err := c.FirstById(ctx, &got, m.Id); err != nil || got.Name != m.Name {
+		t.Fatal(err)
+	}
+	key := c.cacheKey(&got, m.Id)
+	if _, ok := c.LocalCache.get(key); ok {
+		t.Fatal("过大的值写入了本地缓存")
+	}
+
+	var list []*member
+	if err := c.WarmByIds(ctx, &list, []uint64{m.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(key) {
+		t.Fatal("WarmByIds 写入了过大的值")
+	}
+
+	got = member{}
+	if err := c.FirstByIdSelect(ctx, &got, m.Id, []string{"id", "name"}); err != nil || got.Name != m.Name {
+		t.Fatal(err)
+	}
+	if mr.Exists(c.selectKey(&got, m.Id)) {
+		t.Fatal("FirstByIdSelect 写入了过大的值")
+	}
+
+	// SetCache 不写入过大的值, 同时清除旧值
+	if err := c.SetCache(ctx, "blob", "small", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetCache(ctx, "blob", m.Name, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("test:blob") {
+		t.Fatal("SetCache 写入了过大的值")
+	}
+}
+
+// 使用 slug 关联id
+type memberSlugFinder struct{}
+
+func (memberSlugFinder) Find(ctx context.Context, db *gorm.DB, field string) (uint64, error) {
+	var m member
+	err := db.Where("slug = ?", field).First(&m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return m.Id, err
+}
+
+func (memberSlugFinder) FieldValue(model interface{}) string {
+	return model.(*member).Slug
+}
+
+func TestSingleflightKeysDoNotCollide(t *testing.T) {
+	ctx := context.Background()
+	// linkType 为 id 时 link 缓存的key与id缓存的key相同
+	c, _ := newTestModelFunc(t, WithLinkMap(map[string]LinkFinder{"id": memberSlugFinder{}}))
+	if c.linkKey("id", "1") != c.cacheKey(&member{}, uint64(1)) {
+		t.Fatalf("link key %s 与 id key %s 需要相同", c.linkKey("id", "1"), c.cacheKey(&member{}, uint64(1)))
+	}
+	if err := c.Create(ctx, &member{Id: 1, Name: "by-id"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(ctx, &member{Id: 2, Name: "by-link", Slug: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	slowQuery(t, c.MysqlCient, 50*time.Millisecond)
+
+	// 同时查询 id 1 和 slug "1", 两个查询不能共享结果
+	var byId, byLink member
+	var errId, errLink error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errId = c.FirstById(ctx, &byId, 1)
+	}()
+	go func() {
+		defer wg.Done()
+		errLink = c.FirstByLink(ctx, "id", &byLink, "1")
+	}()
+	wg.Wait()
+	if errId != nil || byId.Name != "by-id" {
+		t.Fatalf("FirstById = %v, %+v", errId, byId)
+	}
+	if errLink != nil || byLink.Name != "by-link" {
+		t.Fatalf("FirstByLink = %v, %+v", errLink, byLink)
+	}
+}
+
+func TestCreateValidatesConfig(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestModelFunc(t, WithNegativeExpire(time.Second))
+	c.RedisClient = nil
+	if err := c.Create(ctx, &member{Name: "a"}); err == nil {
+		t.Fatal("RedisClient 为空时 Create 需要返回配置错误")
+	}
+	if err := c.CreateBatch(ctx, []*member{{Name: "b"}}, 10); err == nil {
+		t.Fatal("RedisClient 为空时 CreateBatch 需要返回配置错误")
+	}
+}
+
+// 序列化结果原样返回, 模拟以 0x00 开头的二进制序列化
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) { return *v.(*[]byte), nil }
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func TestDecodeZeroLeadingByte(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestModelFunc(t, WithCodec(rawCodec{}))
+	value := []byte{0x00}
+	if err := c.SetCache(ctx, "zero", &value, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	if found, err := c.GetCache(ctx, "zero", &got); err != nil || !found || !bytes.Equal(got, value) {
+		t.Fatal(found, err, got)
+	}
+}
+
+func TestFirstByIdsOnRing(t *testing.T) {
+	ctx := context.Background()
+	mr1, mr2 := miniredis.RunT(t), miniredis.RunT(t)
+	ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"a": mr1.Addr(), "b": mr2.Addr()}})
+	defer ring.Close()
+	queries := 0
+	c, _ := newTestModelFunc(t, WithCache(ring, "test:", time.Minute), WithOnQuery(func(sql string, d time.Duration, rows int64) {
+		if strings.HasPrefix(sql, "SELECT") {
+			queries++
+		}
+	}))
+
+	ids := make([]uint64, 0, 20)
+	emails := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		m := &member{Name: "ring", Email: "ring" + string(rune('a'+i)) + "@x"}
+		if err := c.Create(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.Id)
+		emails = append(emails, m.Email)
+	}
+	var list []*member
+	if err := c.FirstByIds(ctx, &list, ids); err != nil || len(list) != len(ids) {
+		t.Fatal(err, len(list))
+	}
+	if err := c.WarmLinks(ctx, "email", emails); err != nil {
+		t.Fatal(err)
+	}
+	if len(mr1.Keys()) == 0 || len(mr2.Keys()) == 0 {
+		t.Fatal("缓存没有分布到两个分片")
+	}
+
+	// 两个分片上的缓存都需要命中, 不再查询数据库
+	queries = 0
+	list = nil
+	if err := c.FirstByIds(ctx, &list, ids); err != nil || len(list) != len(ids) {
+		t.Fatal(err, len(list))
+	}
+	list = nil
+	if err := c.FirstByLinks(ctx, "email", &list, emails); err != nil || len(list) != len(ids) {
+		t.Fatal(err, len(list))
+	}
+	if err := c.WarmLinks(ctx, "email", emails); err != nil {
+		t.Fatal(err)
+	}
+	if queries != 0 {
+		t.Fatalf("缓存已经写入, 仍然查询了 %d 次数据库", queries)
+	}
+}
+
+// 查询数据库前等待 d, 使并发的请求都在查询完成之前未命中缓存
+func slowQuery(t *testing.T, db *gorm.DB, d time.Duration) {
+	if err := db.Callback().Query().Before("gorm:query").Register("test:slow", func(*gorm.DB) { time.Sleep(d) }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 统计执行的 SELECT 语句数
+func countSelects(queries *int32) Option {
+	return WithOnQuery(func(sql string, d time.Duration, rows int64) {
+		if strings.HasPrefix(sql, "SELECT") {
+			atomic.AddInt32(queries, 1)
+		}
+	})
+}
+
+func TestFirstByIdSingleflight(t *testing.T) {
+	ctx := context.Background()
+	var queries int32
+	c, _ := newTestModelFunc(t, countSelects(&queries))
+	m := &member{Name: "hot"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	slowQuery(t, c.MysqlCient, 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got member
+			if err := c.FirstById(ctx, &got, m.Id); err != nil || got.Name != "hot" {
+				t.Errorf("FirstById = %v, %+v", err, got)
+			}
+		}()
+	}
+	wg.Wait()
+	if queries != 1 {
+		t.Fatalf("20 个并发请求查询了 %d 次数据库, 需要合并为1次", queries)
+	}
+}
+
+func TestFirstByIdSingleflightErrorNotCached(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestModelFunc(t)
+	m := &member{Name: "hot"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	errDown := errors.New("db down")
+	failing := int32(1)
+	slowQuery(t, c.MysqlCient, 50*time.Millisecond)
+	err := c.MysqlCient.Callback().Query().Before("gorm:query").Register("test:fail", func(db *gorm.DB) {
+		if atomic.LoadInt32(&failing) == 1 {
+			_ = db.AddError(errDown)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 等待中的请求都收到数据库的错误
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got member
+			if err := c.FirstById(ctx, &got, m.Id); !errors.Is(err, errDown) {
+				t.Errorf("FirstById = %v, 需要返回数据库的错误", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if mr.Exists(c.cacheKey(m, m.Id)) {
+		t.Fatal("查询出错时写入了缓存")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	var got member
+	if err = c.FirstById(ctx, &got, m.Id); err != nil || got.Name != "hot" {
+		t.Fatal(err, got)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	var queries int32
+	c, mr := newTestModelFunc(t, WithNegativeExpire(time.Minute), countSelects(&queries))
+
+	var got member
+	if err := c.FirstById(ctx, &got, 42); !ErrIsGormNil(err) {
+		t.Fatal(err)
+	}
+	if v, _ := mr.Get(c.cacheKey(&got, uint64(42))); v != nilCacheValue {
+		t.Fatalf("不存在的记录没有写入空值缓存: %q", v)
+	}
+	if ttl := mr.TTL(c.cacheKey(&got, uint64(42))); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("空值缓存的过期间隔为 %s", ttl)
+	}
+
+	// 命中空值缓存, 不再查询数据库
+	queries = 0
+	if err := c.FirstById(ctx, &got, 42); !ErrIsGormNil(err) {
+		t.Fatal(err)
+	}
+	if queries != 0 {
+		t.Fatalf("命中空值缓存仍然查询了 %d 次数据库", queries)
+	}
+
+	// 新增记录后删除空值缓存
+	if err := c.Create(ctx, &member{Id: 42, Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FirstById(ctx, &got, 42); err != nil || got.Name != "new" {
+		t.Fatal(err, got)
+	}
+}
+
+func TestLocalCacheLRU(t *testing.T) {
+	l := NewLocalCache(2, 0, time.Minute)
+	l.set("a", []byte("1"))
+	l.set("b", []byte("2"))
+	l.get("a")
+	l.set("c", []byte("3"))
+	if _, ok := l.get("b"); ok {
+		t.Fatal("超过条数上限时需要淘汰最久未使用的 b")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := l.get(key); !ok {
+			t.Fatalf("%s 不应该被淘汰", key)
+		}
+	}
+
+	l = NewLocalCache(0, 10, time.Minute)
+	l.set("a", []byte("123456"))
+	l.set("b", []byte("123456"))
+	if _, ok := l.get("a"); ok {
+		t.Fatal("超过字节上限时需要淘汰 a")
+	}
+	l.set("big", make([]byte, 11))
+	if _, ok := l.get("big"); ok {
+		t.Fatal("超过字节上限的单条数据不应该缓存")
+	}
+}
+
+func TestLocalCacheTTL(t *testing.T) {
+	l := NewLocalCache(10, 0, 20*time.Millisecond)
+	l.set("a", []byte("1"))
+	if _, ok := l.get("a"); !ok {
+		t.Fatal("ttl 之内需要命中")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := l.get("a"); ok {
+		t.Fatal("ttl 之后不应该命中")
+	}
+}
+
+func TestFirstByIdLocalCache(t *testing.T) {
+	ctx := context.Background()
+	var queries int32
+	c, mr := newTestModelFunc(t, WithLocalCache(100, 0, time.Minute), countSelects(&queries))
+	m := &member{Name: "a"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	var got member
+	if err := c.FirstById(ctx, &got, m.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// redis 中的缓存被清空后仍然命中本地缓存
+	mr.FlushAll()
+	queries = 0
+	got = member{}
+	if hit, err := c.FirstByIdWithMeta(ctx, &got, m.Id); err != nil || !hit || got.Name != "a" {
+		t.Fatal(hit, err, got)
+	}
+	if queries != 0 {
+		t.Fatalf("命中本地缓存仍然查询了 %d 次数据库", queries)
+	}
+
+	// 更新时清除本地缓存
+	if err := c.UpdateById(ctx, &member{Name: "b"}, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FirstById(ctx, &got, m.Id); err != nil || got.Name != "b" {
+		t.Fatal(err, got)
+	}
+}
+
+// 模拟 redis 故障, 接下来的 fails 个命令或者 pipeline 返回 err, 不发送到 redis
+type faultHook struct {
+	err   error
+	fails int32
+	calls int32
+}
+
+func (h *faultHook) fault() error {
+	atomic.AddInt32(&h.calls, 1)
+	if atomic.AddInt32(&h.fails, -1) >= 0 {
+		return h.err
+	}
+	return nil
+}
+
+func (h *faultHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h.fault()
+}
+
+func (h *faultHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *faultHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, h.fault()
+}
+
+func (h *faultHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestCacheFailOpen(t *testing.T) {
+	for _, failOpen := range []bool{false, true} {
+		name := "FailClosed"
+		var opts []Option
+		if failOpen {
+			name = "FailOpen"
+			opts = append(opts, WithCacheFailOpen())
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c, _ := newTestModelFunc(t, opts...)
+			m := &member{Name: "a"}
+			if err := c.Create(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+			down := &faultHook{err: errors.New("redis down"), fails: 1 << 30}
+			c.RedisClient.(*redis.Client).AddHook(down)
+
+			var got member
+			err := c.FirstById(ctx, &got, m.Id)
+			if failOpen && (err != nil || got.Name != "a") {
+				t.Fatalf("开启 CacheFailOpen 时 FirstById 需要回源数据库: %v, %+v", err, got)
+			} else if !failOpen && err == nil {
+				t.Fatal("没有开启 CacheFailOpen 时 FirstById 需要返回 redis 的错误")
+			}
+
+			err = c.UpdateById(ctx, &member{Name: "b"}, m.Id)
+			if failOpen && err != nil {
+				t.Fatalf("开启 CacheFailOpen 时清除缓存失败不应该返回错误: %v", err)
+			} else if !failOpen && err == nil {
+				t.Fatal("没有开启 CacheFailOpen 时 UpdateById 需要返回清除缓存的错误")
+			}
+			// 两种情况下数据库都已经更新
+			if err = c.MysqlCient.First(&got, m.Id).Error; err != nil || got.Name != "b" {
+				t.Fatal(err, got)
+			}
+		})
+	}
+}
+
+type article struct {
+	Id      uint64 `gorm:"primaryKey"`
+	Title   string
+	Version int
+}
+
+func TestOptimisticLock(t *testing.T) {
+	ctx := context.Background()
+	base, _ := newTestModelFunc(t)
+	if err := base.MysqlCient.AutoMigrate(&article{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(base.MysqlCient, WithCache(base.RedisClient, "article:", time.Minute), WithVersionColumn("version"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &article{Title: "a", Version: 1}
+	if err = c.Create(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个请求读取到相同的版本号, 先更新的成功, 后更新的返回 ErrOptimisticLock
+	var first, second article
+	if err = c.FirstById(ctx, &first, a.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.FirstById(ctx, &second, a.Id); err != nil {
+		t.Fatal(err)
+	}
+	first.Title = "b"
+	if err = c.UpdateById(ctx, &first, a.Id); err != nil || first.Version != 2 {
+		t.Fatal(err, first)
+	}
+	second.Title = "c"
+	if err = c.UpdateById(ctx, &second, a.Id); !errors.Is(err, ErrOptimisticLock) {
+		t.Fatalf("版本号过期时 UpdateById = %v, 需要返回 ErrOptimisticLock", err)
+	}
+	if second.Version != 1 {
+		t.Fatalf("更新失败后模型中的版本号需要恢复为 1, 实际为 %d", second.Version)
+	}
+
+	var got article
+	if err = c.FirstById(ctx, &got, a.Id); err != nil || got.Title != "b" || got.Version != 2 {
+		t.Fatal(err, got)
+	}
+}
+
+func TestRedisRetry(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestModelFunc(t, WithRetry(2, time.Millisecond))
+	blip := &faultHook{err: io.EOF}
+	c.RedisClient.(*redis.Client).AddHook(blip)
+
+	// 连续失败的次数不超过 MaxRetries 时重试成功
+	blip.fails = 2
+	if err := c.SetCache(ctx, "k", "v", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	blip.fails = 2
+	var v string
+	if found, err := c.GetCache(ctx, "k", &v); err != nil || !found || v != "v" {
+		t.Fatal(found, err, v)
+	}
+
+	// 超过 MaxRetries 时返回最后一次的错误
+	blip.fails = 3
+	if err := c.SetCache(ctx, "k", "v", time.Minute); !errors.Is(err, io.EOF) {
+		t.Fatalf("超过重试次数时 SetCache = %v, 需要返回 io.EOF", err)
+	}
+
+	// redis.Nil 不重试
+	blip.fails, blip.calls = 0, 0
+	if found, err := c.GetCache(ctx, "missing", &v); err != nil || found {
+		t.Fatal(found, err)
+	}
+	if blip.calls != 1 {
+		t.Fatalf("key 不存在时执行了 %d 次 GET, 不应该重试", blip.calls)
+	}
+}
+
+func TestRedisRetryRespectsDeadline(t *testing.T) {
+	c, _ := newTestModelFunc(t, WithRetry(5, time.Hour))
+	c.RedisClient.(*redis.Client).AddHook(&faultHook{err: io.EOF, fails: 1 << 30})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.SetCache(ctx, "k", "v", time.Minute); !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("重试等待超过了 ctx 的超时时间: %s", d)
+	}
+}
+
+func TestDryRunPreview(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestModelFunc(t, WithDryRun())
+	m := &member{Name: "a", Email: "dry@x"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	var got member
+	if err := c.FirstById(ctx, &got, m.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := c.DeleteByIdWithCount(ctx, &member{}, m.Id); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if n, err := c.SoftDeleteByIdWithCount(ctx, &member{}, m.Id); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if n, err := c.DeleteByCondition(ctx, &member{}, "name = ?", "a"); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if err := c.DeleteByLink(ctx, "email", &member{}, "dry@x"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 数据库和缓存都没有变化, DeleteByLink 没有写入link缓存
+	if err := c.MysqlCient.First(&got, m.Id).Error; err != nil || !got.DeletedAt.IsZero() {
+		t.Fatal(err, got)
+	}
+	if !mr.Exists(c.cacheKey(&got, m.Id)) {
+		t.Fatal("DryRun 清除了id缓存")
+	}
+	if mr.Exists(c.linkKey("email", "dry@x")) {
+		t.Fatal("DryRun 时 DeleteByLink 写入了link缓存")
+	}
+}
+
+func TestUpdateByIdIfNoRows(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestModelFunc(t)
+	if err := c.UpdateByIdIf(ctx, &member{Name: "zz"}, 12345); !errors.Is(err, ErrNoRowsAffected) {
+		t.Fatalf("记录不存在时 UpdateByIdIf = %v, 需要返回 ErrNoRowsAffected", err)
+	}
+
+	if err := c.MysqlCient.AutoMigrate(&article{}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := New(c.MysqlCient, WithCache(c.RedisClient, "article:", time.Minute), WithVersionColumn("version"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &article{Title: "a", Version: 1}
+	if err = v.Create(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.UpdateByIdIf(ctx, &article{Title: "b", Version: 1}, 12345); !errors.Is(err, ErrNoRowsAffected) {
+		t.Fatalf("设置 VersionColumn 时记录不存在 UpdateByIdIf = %v, 需要返回 ErrNoRowsAffected", err)
+	}
+	if err = v.UpdateByIdIf(ctx, &article{Title: "b", Version: 5}, a.Id); !errors.Is(err, ErrNoRowsAffected) {
+		t.Fatalf("版本号不匹配时 UpdateByIdIf = %v, 需要返回 ErrNoRowsAffected", err)
+	}
+	if err = v.UpdateByIdIf(ctx, &article{Title: "b", Version: 1}, a.Id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactionDefaultTimeout(t *testing.T) {
+	c, _ := newTestModelFunc(t, WithDefaultTimeout(time.Minute))
+	err := c.Transaction(context.Background(), func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return errors.New("Transaction 的 ctx 没有使用 DefaultTimeout")
+		}
+		return c.Create(ctx, &member{Name: "tx"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypedNilRedisClient(t *testing.T) {
+	ctx := context.Background()
+	base, _ := newTestModelFunc(t)
+	var rdc *redis.Client
+	if _, err := New(base.MysqlCient, WithCache(rdc, "test:", time.Minute)); err == nil {
+		t.Fatal("WithCache 需要拒绝值为 nil 的 *redis.Client")
+	}
+	c := &ModelFunc{MysqlCient: base.MysqlCient, UseCache: true, RedisClient: rdc}
+	if err := c.Validate(); err == nil {
+		t.Fatal("Validate 需要拒绝值为 nil 的 *redis.Client")
+	}
+	if err := c.FirstById(ctx, &member{}, 1); err == nil {
+		t.Fatal("FirstById 需要返回配置错误")
+	}
+	if err := c.SetCache(ctx, "k", "v", time.Minute); err == nil {
+		t.Fatal("SetCache 需要返回配置错误")
+	}
+}
+
+func TestQueryMethodsValidateConfig(t *testing.T) {
+	ctx := context.Background()
+	c := &ModelFunc{}
+	var list []*member
+	if _, err := c.Count(ctx, &member{}); err == nil {
+		t.Fatal("MysqlCient 为空时 Count 需要返回配置错误")
+	}
+	if err := c.FindByCondition(ctx, &list); err == nil {
+		t.Fatal("MysqlCient 为空时 FindByCondition 需要返回配置错误")
+	}
+	if _, err := c.Paginate(ctx, &list, 1, 10); err == nil {
+		t.Fatal("MysqlCient 为空时 Paginate 需要返回配置错误")
+	}
+}
+
+func TestKeyFuncKeepsCacheVersion(t *testing.T) {
+	ctx := context.Background()
+	keyFunc := func(prefix string, id interface{}) string {
+		return prefix + "m:" + keyString(id)
+	}
+	c, mr := newTestModelFunc(t, WithCacheVersion("v1"))
+	c.KeyFunc = keyFunc
+	m := &member{Name: "a"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	var got member
+	if err := c.FirstById(ctx, &got, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("test:v1:m:" + keyString(m.Id)) {
+		t.Fatalf("KeyFunc 生成的key没有包含 CacheVersion: %v", mr.Keys())
+	}
+
+	// 修改版本后不再读取旧缓存
+	if err := c.MysqlCient.Model(&member{}).Where("id = ?", m.Id).Update("name", "b").Error; err != nil {
+		t.Fatal(err)
+	}
+	c.CacheVersion = "v2"
+	if err := c.FirstById(ctx, &got, m.Id); err != nil || got.Name != "b" {
+		t.Fatal(err, got)
+	}
+}
+
+type entry struct {
+	Id   uint64 `gorm:"primaryKey"`
+	Name string
+}
+
+var entryBatches []int
+
+func (e *entry) MfAfterCreateBatch(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient, models []*entry) error {
+	entryBatches = append(entryBatches, len(models))
+	return nil
+}
+
+func TestCreateBatch(t *testing.T) {
+	ctx := context.Background()
+	base, mr := newTestModelFunc(t)
+	if err := base.MysqlCient.AutoMigrate(&entry{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(base.MysqlCient, WithCache(base.RedisClient, "entry:", time.Minute), WithNegativeExpire(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 5; id++ {
+		if err = c.FirstById(ctx, &entry{}, id); !ErrIsGormNil(err) {
+			t.Fatal(err)
+		}
+	}
+
+	if err = c.CreateBatch(ctx, &entry{}, 2); err == nil {
+		t.Fatal("models 不是切片时需要返回错误")
+	}
+	counter := &faultHook{}
+	c.RedisClient.(*redis.Client).AddHook(counter)
+	entryBatches = nil
+	list := []*entry{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+	if err = c.CreateBatch(ctx, list, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// 空值缓存使用一个 pipeline 删除, 批量钩子每个批次执行一次
+	if counter.calls != 1 {
+		t.Fatalf("删除空值缓存执行了 %d 次 redis 请求, 需要合并为1次", counter.calls)
+	}
+	for _, e := range list {
+		if mr.Exists(c.cacheKey(e, e.Id)) {
+			t.Fatalf("id %d 的空值缓存没有删除", e.Id)
+		}
+	}
+	if len(entryBatches) != 3 || entryBatches[0] != 2 || entryBatches[1] != 2 || entryBatches[2] != 1 {
+		t.Fatalf("批量钩子收到的批次为 %v, 需要为 [2 2 1]", entryBatches)
+	}
+}
+
+func TestWarmByIdsRetryAndFailOpen(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestModelFunc(t, WithRetry(1, 0))
+	m := &member{Name: "a"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	fault := &faultHook{err: io.EOF, fails: 1}
+	c.RedisClient.(*redis.Client).AddHook(fault)
+
+	// pipeline 第一次失败后重试成功
+	var list []*member
+	if err := c.WarmByIds(ctx, &list, []uint64{m.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists(c.cacheKey(m, m.Id)) {
+		t.Fatal("重试后没有写入缓存")
+	}
+
+	// 开启 CacheFailOpen 时写入缓存失败不返回错误
+	fault.fails = 1 << 30
+	if err := c.WarmByIds(ctx, &list, []uint64{m.Id}); err == nil {
+		t.Fatal("没有开启 CacheFailOpen 时需要返回 redis 的错误")
+	}
+	c.CacheFailOpen = true
+	if err := c.WarmByIds(ctx, &list, []uint64{m.Id}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 按方法名记录缓存命中和错误
+type opRecorder struct {
+	NopMetrics
+	hits, misses, errs map[string]int
+}
+
+func newOpRecorder() *opRecorder {
+	return &opRecorder{hits: map[string]int{}, misses: map[string]int{}, errs: map[string]int{}}
+}
+
+func (r *opRecorder) ObserveCacheHit(op string)         { r.hits[op]++ }
+func (r *opRecorder) ObserveCacheMiss(op string)        { r.misses[op]++ }
+func (r *opRecorder) ObserveError(op string, err error) { r.errs[op]++ }
+
+// 记录开启的 span 名称
+type spanRecorder struct {
+	embedded.Tracer
+	names []string
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	r.names = append(r.names, name)
+	return noop.NewTracerProvider().Tracer("").Start(ctx, name, opts...)
+}
+
+func TestExpireByIdAndInvalidateLinkMetrics(t *testing.T) {
+	ctx := context.Background()
+	rec := newOpRecorder()
+	spans := &spanRecorder{}
+	c, mr := newTestModelFunc(t, WithMetrics(rec), WithTracer(spans))
+	m := &member{Name: "a", Email: "e@x"}
+	if err := c.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExpireById(ctx, &member{}, m.Id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var got member
+	if err := c.FirstById(ctx, &got, m.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExpireById(ctx, &member{}, m.Id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if mr.TTL(c.cacheKey(&got, m.Id)) != time.Hour {
+		t.Fatal("ExpireById 没有修改过期时间")
+	}
+	if rec.misses["ExpireById"] != 1 || rec.hits["ExpireById"] != 1 {
+		t.Fatalf("ExpireById 命中 %d 次, 未命中 %d 次", rec.hits["ExpireById"], rec.misses["ExpireById"])
+	}
+
+	if err := c.InvalidateLink(ctx, "email", ""); err == nil {
+		t.Fatal("field 为空时需要返回错误")
+	}
+	if rec.errs["InvalidateLink"] != 1 {
+		t.Fatal("InvalidateLink 的错误没有记录到 Metrics")
+	}
+	if strings.Join(spans.names, ",") != "mf.ExpireById,mf.FirstById,mf.ExpireById,mf.InvalidateLink" {
+		t.Fatalf("span 为 %v", spans.names)
+	}
+}
+
+type ledger struct {
+	Id        uint64 `gorm:"primaryKey"`
+	Name      string
+	DeletedAt time.Time
+}
+
+var (
+	_ AfterCreateBatchHook     = (*ledger)(nil)
+	_ AfterSoftDeleteByIdsHook = (*ledger)(nil)
+)
+
+var ledgerCalls []string
+
+func (l *ledger) MfAfterCreateBatch(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient, models interface{}) error {
+	ledgerCalls = append(ledgerCalls, "create:"+keyString(len(models.([]*ledger))))
+	return nil
+}
+
+func (l *ledger) MfAfterSoftDeleteByIds(ctx context.Context, db *gorm.DB, rdc redis.UniversalClient, ids []uint64) error {
+	ledgerCalls = append(ledgerCalls, "delete:"+keyString(len(ids)))
+	return nil
+}
+
+func TestBatchHookInterfaces(t *testing.T) {
+	ctx := context.Background()
+	base, _ := newTestModelFunc(t)
+	if err := base.MysqlCient.AutoMigrate(&ledger{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(base.MysqlCient, WithCache(base.RedisClient, "ledger:", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledgerCalls = nil
+	list := []*ledger{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	if err = c.CreateBatch(ctx, list, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SoftDeleteByIds(ctx, &ledger{}, []uint64{list[0].Id, list[1].Id}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ledgerCalls, ",") != "create:3,delete:2" {
+		t.Fatalf("批量钩子调用为 %v", ledgerCalls)
+	}
+}
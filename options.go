@@ -0,0 +1,380 @@
+package mf
+
+import (
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Option ModelFunc 的配置项, 配置有误时返回错误
+type Option func(c *ModelFunc) error
+
+// 使用配置项创建 ModelFunc, 创建后会校验配置
+func New(db *gorm.DB, opts ...Option) (*ModelFunc, error) {
+	c := &ModelFunc{MysqlCient: db}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if err := c.ValidateLinks(); err != nil {
+		return nil, err
+	}
+	if c.NegativeExpire > 0 && !c.UseCache {
+		return nil, errors.New("WithNegativeExpire 需要同时使用 WithCache")
+	}
+	return c, nil
+}
+
+// 开启缓存
+func WithCache(rdc redis.UniversalClient, prefix string, expire time.Duration) Option {
+	return func(c *ModelFunc) error {
+		if nilRedis(rdc) {
+			return errors.New("WithCache 参数 rdc 不能为空")
+		}
+		c.UseCache = true
+		c.RedisClient = rdc
+		c.RedisPrefix = prefix
+		c.Expire = expire
+		return nil
+	}
+}
+
+func WithLinkMap(linkMap map[string]LinkFinder) Option {
+	return func(c *ModelFunc) error {
+		for linkType, finder := range linkMap {
+			if finder == nil {
+				return errors.New("WithLinkMap 的 LinkFinder 不能为空: " + linkType)
+			}
+		}
+		c.LinkMap = linkMap
+		return nil
+	}
+}
+
+// 按表名设置 redis 缓存过期间隔, 没有设置的表使用 Expire
+func WithExpireMap(expireMap map[string]time.Duration) Option {
+	return func(c *ModelFunc) error {
+		c.ExpireMap = expireMap
+		return nil
+	}
+}
+
+// 设置 link 缓存过期间隔, linkExpireMap 可以为空
+func WithLinkExpire(expire time.Duration, linkExpireMap map[string]time.Duration) Option {
+	return func(c *ModelFunc) error {
+		c.LinkExpire = expire
+		c.LinkExpireMap = linkExpireMap
+		return nil
+	}
+}
+
+func WithPrimaryKey(column string) Option {
+	return func(c *ModelFunc) error {
+		if column == "" {
+			return errors.New("WithPrimaryKey 参数 column 不能为空")
+		}
+		c.PrimaryKey = column
+		return nil
+	}
+}
+
+func WithSoftDelete(column string, style SoftDeleteStyle) Option {
+	return func(c *ModelFunc) error {
+		c.SoftDeleteColumn = column
+		c.SoftDeleteStyle = style
+		return nil
+	}
+}
+
+// 设置 Touch 更新的时间字段名
+func WithUpdatedAtColumn(column string) Option {
+	return func(c *ModelFunc) error {
+		if column == "" {
+			return errors.New("WithUpdatedAtColumn 参数 column 不能为空")
+		}
+		c.UpdatedAtColumn = column
+		return nil
+	}
+}
+
+// 开启空值缓存, 需要同时使用 WithCache
+func WithNegativeExpire(expire time.Duration) Option {
+	return func(c *ModelFunc) error {
+		c.NegativeExpire = expire
+		return nil
+	}
+}
+
+func WithExpireJitter(jitter time.Duration) Option {
+	return func(c *ModelFunc) error {
+		if jitter < 0 {
+			return errors.New("WithExpireJitter 参数 jitter 不能小于0")
+		}
+		c.ExpireJitter = jitter
+		return nil
+	}
+}
+
+func WithCacheVersion(version string) Option {
+	return func(c *ModelFunc) error {
+		c.CacheVersion = version
+		return nil
+	}
+}
+
+func WithCodec(codec Codec) Option {
+	return func(c *ModelFunc) error {
+		if codec == nil {
+			return errors.New("WithCodec 参数 codec 不能为空")
+		}
+		c.Codec = codec
+		return nil
+	}
+}
+
+// 超过 minBytes 的缓存数据使用 gzip 压缩
+func WithCompress(minBytes int) Option {
+	return func(c *ModelFunc) error {
+		c.CompressCache = true
+		c.CompressMinBytes = minBytes
+		return nil
+	}
+}
+
+func WithUniqueColumns(columns ...string) Option {
+	return func(c *ModelFunc) error {
+		c.UniqueColumns = columns
+		return nil
+	}
+}
+
+func WithMaxPageSize(size int) Option {
+	return func(c *ModelFunc) error {
+		c.MaxPageSize = size
+		return nil
+	}
+}
+
+func WithMetrics(metrics Metrics) Option {
+	return func(c *ModelFunc) error {
+		c.Metrics = metrics
+		return nil
+	}
+}
+
+func WithTracer(tracer trace.Tracer) Option {
+	return func(c *ModelFunc) error {
+		c.Tracer = tracer
+		return nil
+	}
+}
+
+// 设置写入软删时间的时区, 兼容旧版本可以使用 time.FixedZone("CST", 8*3600)
+func WithLocation(loc *time.Location) Option {
+	return func(c *ModelFunc) error {
+		if loc == nil {
+			return errors.New("WithLocation 参数 loc 不能为空")
+		}
+		c.Location = loc
+		return nil
+	}
+}
+
+// 设置每次调用的最长执行时间, 调用方传入的 ctx 没有超时时也能兜底
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return func(c *ModelFunc) error {
+		c.DefaultTimeout = timeout
+		return nil
+	}
+}
+
+// 开启进程内 LRU 缓存, maxEntries、maxBytes 为0时不限制, ttl 限制多实例部署时本地缓存过期的时间
+func WithLocalCache(maxEntries, maxBytes int, ttl time.Duration) Option {
+	return func(c *ModelFunc) error {
+		if ttl <= 0 {
+			return errors.New("WithLocalCache 参数 ttl 必须大于0")
+		}
+		c.LocalCache = NewLocalCache(maxEntries, maxBytes, ttl)
+		return nil
+	}
+}
+
+// FirstById 记录不存在时返回 nil 而不是 gorm.ErrRecordNotFound
+func WithTreatNotFoundAsNil() Option {
+	return func(c *ModelFunc) error {
+		c.TreatNotFoundAsNil = true
+		return nil
+	}
+}
+
+// redis 出错时读取回源数据库, 写入不因清除缓存失败而返回错误
+func WithCacheFailOpen() Option {
+	return func(c *ModelFunc) error {
+		c.CacheFailOpen = true
+		return nil
+	}
+}
+
+// 开启乐观锁, column 为版本号字段名
+func WithVersionColumn(column string) Option {
+	return func(c *ModelFunc) error {
+		if column == "" {
+			return errors.New("WithVersionColumn 参数 column 不能为空")
+		}
+		c.VersionColumn = column
+		return nil
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(c *ModelFunc) error {
+		c.Logger = logger
+		return nil
+	}
+}
+
+// 缓存key中拼接模型的表名, 一个 ModelFunc 可以同时用于多个模型
+func WithQualifyTable() Option {
+	return func(c *ModelFunc) error {
+		c.QualifyTable = true
+		return nil
+	}
+}
+
+// SaveById 前检查模型是否完整
+func WithStrictSave() Option {
+	return func(c *ModelFunc) error {
+		c.StrictSave = true
+		return nil
+	}
+}
+
+// redis 命令遇到网络错误时重试, backoff 为第一次重试前的等待时间, 之后每次翻倍
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(c *ModelFunc) error {
+		if maxRetries < 0 || backoff < 0 {
+			return errors.New("WithRetry 参数 maxRetries、backoff 不能小于0")
+		}
+		c.MaxRetries = maxRetries
+		c.RetryBackoff = backoff
+		return nil
+	}
+}
+
+// 设置 FirstByIdPrimary 强制读主库使用的 scope, 例如 func(db *gorm.DB) *gorm.DB { return db.Clauses(dbresolver.Write) }
+func WithPrimaryScope(scope func(*gorm.DB) *gorm.DB) Option {
+	return func(c *ModelFunc) error {
+		if scope == nil {
+			return errors.New("WithPrimaryScope 参数 scope 不能为空")
+		}
+		c.PrimaryScope = scope
+		return nil
+	}
+}
+
+// FirstById 命中缓存时重新设置过期时间
+func WithSlidingExpire() Option {
+	return func(c *ModelFunc) error {
+		c.SlidingExpire = true
+		return nil
+	}
+}
+
+// 设置获取当前时间的方法, 测试时可以注入固定的时间
+func WithNow(now func() time.Time) Option {
+	return func(c *ModelFunc) error {
+		if now == nil {
+			return errors.New("WithNow 参数 now 不能为空")
+		}
+		c.Now = now
+		return nil
+	}
+}
+
+// 设置不写入缓存的结构体字段名
+func WithCacheExclude(fields ...string) Option {
+	return func(c *ModelFunc) error {
+		c.CacheExclude = append(c.CacheExclude, fields...)
+		return nil
+	}
+}
+
+// FirstByLink 同时在link下缓存整条记录
+func WithLinkCacheRow() Option {
+	return func(c *ModelFunc) error {
+		c.LinkCacheRow = true
+		return nil
+	}
+}
+
+// UpdateById、SaveById 写入数据库后把模型写入缓存, 模型需要是完整的记录
+func WithCacheWriteThrough() Option {
+	return func(c *ModelFunc) error {
+		c.CacheWriteThrough = true
+		return nil
+	}
+}
+
+// 删除和软删方法只预览不执行
+func WithDryRun() Option {
+	return func(c *ModelFunc) error {
+		c.DryRun = true
+		return nil
+	}
+}
+
+// 序列化后超过 maxBytes 字节的值不写入缓存
+func WithMaxCacheBytes(maxBytes int) Option {
+	return func(c *ModelFunc) error {
+		if maxBytes <= 0 {
+			return errors.New("WithMaxCacheBytes 参数 maxBytes 必须大于0")
+		}
+		c.MaxCacheBytes = maxBytes
+		return nil
+	}
+}
+
+// 缓存数据损坏时删除缓存并回源数据库
+func WithSelfHealCache() Option {
+	return func(c *ModelFunc) error {
+		c.SelfHealCache = true
+		return nil
+	}
+}
+
+// 每条 SQL 前加上方法名注释
+func WithQueryComment() Option {
+	return func(c *ModelFunc) error {
+		c.QueryComment = true
+		return nil
+	}
+}
+
+// 设置每条 SQL 执行后的回调
+func WithOnQuery(onQuery func(sql string, duration time.Duration, rows int64)) Option {
+	return func(c *ModelFunc) error {
+		if onQuery == nil {
+			return errors.New("WithOnQuery 参数 onQuery 不能为空")
+		}
+		c.OnQuery = onQuery
+		return nil
+	}
+}
+
+// link缓存key中的 field 长度超过 minLen 时使用 sha256 代替原值, minLen 为0时总是哈希
+func WithHashLinkKeys(minLen int) Option {
+	return func(c *ModelFunc) error {
+		if minLen < 0 {
+			return errors.New("WithHashLinkKeys 参数 minLen 不能小于0")
+		}
+		c.HashLinkKeys = true
+		c.HashLinkKeyMinLen = minLen
+		return nil
+	}
+}
@@ -0,0 +1,79 @@
+package mf
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"time"
+)
+
+// 包装 gorm 的 logger, 每条 SQL 执行后调用 onQuery, 原有的日志输出不变
+type queryLogger struct {
+	logger.Interface
+	onQuery func(sql string, duration time.Duration, rows int64)
+}
+
+func (l queryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return queryLogger{Interface: l.Interface.LogMode(level), onQuery: l.onQuery}
+}
+
+func (l queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sql, rows := fc()
+	l.onQuery(sql, time.Since(begin), rows)
+	l.Interface.Trace(ctx, begin, func() (string, int64) { return sql, rows }, err)
+}
+
+// 本次调用使用的数据库链接, 设置 OnQuery 时只在本次会话中替换 logger, 不影响共用 MysqlCient 的其他代码
+// ctx 在 Transaction 中时使用事务的链接
+func (c *ModelFunc) db(ctx context.Context) *gorm.DB {
+	db := c.MysqlCient.WithContext(ctx)
+	if tx := c.tx(ctx); tx != nil {
+		db = tx.db.WithContext(ctx)
+	}
+	if c.QueryComment {
+		if op := opFrom(ctx); op != "" {
+			db = db.Clauses(queryComment{text: "/* mf:" + op + " */"}).Session(&gorm.Session{})
+		}
+	}
+	if c.OnQuery == nil {
+		return db
+	}
+	return db.Session(&gorm.Session{Logger: queryLogger{Interface: db.Logger, onQuery: c.OnQuery}})
+}
+
+type opKey struct{}
+
+// ctx 中记录当前执行的方法名, 由 startSpan 设置, 没有 span 的公开方法在 withTimeout 之后设置
+func withOp(ctx context.Context, op string) context.Context {
+	return context.WithValue(ctx, opKey{}, op)
+}
+
+func opFrom(ctx context.Context) string {
+	op, _ := ctx.Value(opKey{}).(string)
+	return op
+}
+
+// SQL 注释, 写在 SELECT、INSERT、UPDATE、DELETE 之前, 例如 /* mf:FirstById */ SELECT ...
+type queryComment struct {
+	text string
+}
+
+func (queryComment) Name() string {
+	return "MF_COMMENT"
+}
+
+func (q queryComment) Build(builder clause.Builder) {
+	builder.WriteString(q.text)
+}
+
+func (queryComment) MergeClause(*clause.Clause) {}
+
+// 添加到语句时设置为各类语句第一个子句的 BeforeExpression
+func (q queryComment) ModifyStatement(stmt *gorm.Statement) {
+	for _, name := range []string{"SELECT", "INSERT", "UPDATE", "DELETE"} {
+		c := stmt.Clauses[name]
+		c.BeforeExpression = q
+		stmt.Clauses[name] = c
+	}
+}
@@ -0,0 +1,98 @@
+package mf
+
+import (
+	"context"
+)
+
+// Repo 基于 ModelFunc 的泛型封装, T 为模型结构体类型
+type Repo[T any] struct {
+	*ModelFunc
+}
+
+func NewRepo[T any](mf *ModelFunc) *Repo[T] {
+	return &Repo[T]{ModelFunc: mf}
+}
+
+func (r *Repo[T]) Create(ctx context.Context, model *T) error {
+	return r.ModelFunc.Create(ctx, model)
+}
+
+func (r *Repo[T]) CreateBatch(ctx context.Context, models []*T, batchSize int) error {
+	return r.ModelFunc.CreateBatch(ctx, models, batchSize)
+}
+
+func (r *Repo[T]) UpdateById(ctx context.Context, model *T, id uint64) error {
+	return r.ModelFunc.UpdateById(ctx, model, id)
+}
+
+func (r *Repo[T]) SaveById(ctx context.Context, model *T, id uint64) error {
+	return r.ModelFunc.SaveById(ctx, model, id)
+}
+
+// 开启 TreatNotFoundAsNil 时记录不存在返回 nil, nil
+func (r *Repo[T]) FirstById(ctx context.Context, id uint64) (*T, error) {
+	model := new(T)
+	if _, err := r.ModelFunc.firstByKey(ctx, model, id); ErrIsGormNil(err) && r.TreatNotFoundAsNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (r *Repo[T]) FirstByIds(ctx context.Context, ids []uint64) ([]*T, error) {
+	models := make([]*T, 0, len(ids))
+	if err := r.ModelFunc.FirstByIds(ctx, &models, ids); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// 使用id批量查询记录, 返回以id为key的 map, 不存在的id不返回
+func (r *Repo[T]) FirstByIdsMap(ctx context.Context, ids []uint64) (map[uint64]*T, error) {
+	models, err := r.FirstByIds(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uint64]*T, len(models))
+	for _, model := range models {
+		id, err := r.modelId(ctx, model)
+		if err != nil {
+			return nil, err
+		}
+		result[id] = model
+	}
+	return result, nil
+}
+
+func (r *Repo[T]) FirstByLink(ctx context.Context, linkType string, field string) (*T, error) {
+	model := new(T)
+	if err := r.ModelFunc.FirstByLink(ctx, linkType, model, field); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (r *Repo[T]) FirstByIdSD(ctx context.Context, id uint64) (*T, error) {
+	model := new(T)
+	if err := r.ModelFunc.FirstByIdSD(ctx, model, id); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (r *Repo[T]) FirstByLinkSD(ctx context.Context, linkType string, field string) (*T, error) {
+	model := new(T)
+	if err := r.ModelFunc.FirstByLinkSD(ctx, linkType, model, field); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (r *Repo[T]) DeleteById(ctx context.Context, model *T, id uint64) error {
+	return r.ModelFunc.DeleteById(ctx, model, id)
+}
+
+func (r *Repo[T]) SoftDeleteById(ctx context.Context, model *T, id uint64) error {
+	return r.ModelFunc.SoftDeleteById(ctx, model, id)
+}
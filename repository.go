@@ -0,0 +1,91 @@
+package mf
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Repository ModelFunc 的公开方法, 业务代码依赖该接口时可以生成 mock 注入, 不需要启动 mysql 和 redis
+type Repository interface {
+	// 写入
+	Create(ctx context.Context, model interface{}) error
+	CreateReturningId(ctx context.Context, model interface{}) (uint64, error)
+	CreateBatch(ctx context.Context, models interface{}, batchSize int) error
+	FirstOrCreate(ctx context.Context, model interface{}, conds ...interface{}) (bool, error)
+	UpdateById(ctx context.Context, model interface{}, id uint64) error
+	UpdateByKey(ctx context.Context, model interface{}, id interface{}) error
+	UpdateByIdWithCount(ctx context.Context, model interface{}, id uint64) (int64, error)
+	UpdateByIdReturning(ctx context.Context, model interface{}, id uint64) error
+	UpdateByIdIf(ctx context.Context, model interface{}, id uint64, conds ...interface{}) error
+	SaveById(ctx context.Context, model interface{}, id uint64) error
+	SaveByKey(ctx context.Context, model interface{}, id interface{}) error
+	UpdateColumns(ctx context.Context, model interface{}, id uint64, fields map[string]interface{}) error
+	UpsertById(ctx context.Context, model interface{}, id uint64, updateColumns []string) error
+	Touch(ctx context.Context, model interface{}, id uint64) error
+
+	// 查询
+	FirstById(ctx context.Context, model interface{}, id uint64) error
+	FirstByIdWithMeta(ctx context.Context, model interface{}, id uint64) (bool, error)
+	FirstByIdFresh(ctx context.Context, model interface{}, id uint64) error
+	Reload(ctx context.Context, model interface{}) error
+	FirstByIdPrimary(ctx context.Context, model interface{}, id uint64) error
+	FirstByIdScoped(ctx context.Context, model interface{}, id uint64, scopes ...func(*gorm.DB) *gorm.DB) error
+	FirstByIdPreload(ctx context.Context, model interface{}, id uint64, preloads ...string) error
+	FirstByIdCacheOnly(ctx context.Context, model interface{}, id uint64) (bool, error)
+	FirstByIdSelect(ctx context.Context, model interface{}, id uint64, columns []string) error
+	FirstByKey(ctx context.Context, model interface{}, id interface{}) error
+	FirstByUnique(ctx context.Context, model interface{}, column string, value interface{}) error
+	FirstByIds(ctx context.Context, models interface{}, ids []uint64) error
+	FirstByIdsMap(ctx context.Context, modelType interface{}, ids []uint64) (map[uint64]interface{}, error)
+	FirstByLink(ctx context.Context, linkType string, model interface{}, field string) error
+	FirstByLinkWithId(ctx context.Context, linkType string, model interface{}, field string) (uint64, error)
+	FirstByLinks(ctx context.Context, linkType string, models interface{}, fields []string) error
+	FirstByLinkFields(ctx context.Context, linkType string, model interface{}, fields map[string]string) error
+	FirstByIdSD(ctx context.Context, model interface{}, id uint64) error
+	FirstByKeySD(ctx context.Context, model interface{}, id interface{}) error
+	FirstByLinkSD(ctx context.Context, linkType string, model interface{}, field string) error
+
+	// 删除
+	DeleteById(ctx context.Context, model interface{}, id uint64) error
+	DeleteByKey(ctx context.Context, model interface{}, id interface{}) error
+	DeleteByIdWithCount(ctx context.Context, model interface{}, id uint64) (int64, error)
+	HardDeleteById(ctx context.Context, model interface{}, id uint64) error
+	HardDeleteByKey(ctx context.Context, model interface{}, id interface{}) error
+	DeleteByLink(ctx context.Context, linkType string, model interface{}, field string) error
+	SoftDeleteById(ctx context.Context, model interface{}, id uint64) error
+	SoftDeleteByKey(ctx context.Context, model interface{}, id interface{}) error
+	SoftDeleteByIdWithCount(ctx context.Context, model interface{}, id uint64) (int64, error)
+	SoftDeleteByIds(ctx context.Context, model interface{}, ids []uint64) error
+	RestoreById(ctx context.Context, model interface{}, id uint64) error
+	RestoreByKey(ctx context.Context, model interface{}, id interface{}) error
+
+	// 缓存维护
+	WarmById(ctx context.Context, model interface{}, id uint64) error
+	WarmByIds(ctx context.Context, models interface{}, ids []uint64) error
+	WarmLinks(ctx context.Context, linkType string, fields []string) error
+	ExpireById(ctx context.Context, model interface{}, id uint64, ttl time.Duration) error
+	InvalidateById(ctx context.Context, model interface{}, id uint64) error
+	InvalidateLink(ctx context.Context, linkType, field string) error
+	InvalidateKeys(ctx context.Context, keys ...string) error
+	CacheKeys(ctx context.Context, model interface{}, id uint64) []string
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	FlushPrefix(ctx context.Context) error
+	SetCache(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	GetCache(ctx context.Context, key string, dest interface{}) (bool, error)
+
+	// 条件查询
+	Count(ctx context.Context, model interface{}, conds ...interface{}) (int64, error)
+	CountByLink(ctx context.Context, linkType string, field string) (int64, error)
+	FindByCondition(ctx context.Context, models interface{}, conds ...interface{}) error
+	DeleteByCondition(ctx context.Context, model interface{}, conds ...interface{}) (int64, error)
+	Paginate(ctx context.Context, models interface{}, page, pageSize int, conds ...interface{}) (int64, error)
+	Exists(ctx context.Context, model interface{}, id uint64) (bool, error)
+
+	Validate() error
+	LinkTypes() []string
+	ValidateLinks() error
+	Ping(ctx context.Context) error
+}
+
+var _ Repository = (*ModelFunc)(nil)
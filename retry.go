@@ -0,0 +1,51 @@
+package mf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// 执行 redis 命令, 遇到网络错误或者集群切换中的错误时重试, 最多 MaxRetries 次, 间隔从 RetryBackoff 开始翻倍
+func (c *ModelFunc) retry(ctx context.Context, fn func() error) error {
+	err := fn()
+	backoff := c.RetryBackoff
+	for i := 0; i < c.MaxRetries && retryable(err); i++ {
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			backoff *= 2
+		} else if ctx.Err() != nil {
+			return err
+		}
+		err = fn()
+	}
+	return err
+}
+
+// 是否为可以重试的 redis 错误, redis.Nil 和 ctx 超时不重试
+func retryable(err error) bool {
+	if err == nil || ErrIsRedisNil(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// 主从切换、集群迁移时 redis 返回的错误
+	msg := err.Error()
+	for _, prefix := range []string{"LOADING ", "READONLY ", "TRYAGAIN ", "CLUSTERDOWN ", "MASTERDOWN "} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
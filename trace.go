@@ -0,0 +1,34 @@
+package mf
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 开启 span, 名称为 mf.方法名, 未设置 Tracer 时返回不记录的 span, 同时在 ctx 中记录方法名用于 QueryComment
+func (c *ModelFunc) startSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = withOp(ctx, op)
+	if c.Tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return c.Tracer.Start(ctx, "mf."+op, trace.WithAttributes(attrs...))
+}
+
+// 结束 span, 记录不存在不计为错误
+func endSpan(span trace.Span, err error) {
+	if err != nil && !ErrIsGormNil(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func idAttr(id interface{}) attribute.KeyValue {
+	return attribute.String("mf.id", keyString(id))
+}
+
+func hitAttr(hit bool) attribute.KeyValue {
+	return attribute.Bool("mf.cache_hit", hit)
+}
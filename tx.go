@@ -0,0 +1,118 @@
+package mf
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// 每个 MysqlCient 的事务分开保存, 使用其他数据库的 ModelFunc 不会加入该事务
+type txKey struct {
+	origin *gorm.DB
+}
+
+// 事务中使用的数据库链接和提交后需要清除的缓存
+type txState struct {
+	origin *gorm.DB // 开启事务的 MysqlCient
+	db     *gorm.DB
+
+	mu      sync.Mutex
+	pending map[*ModelFunc][]string
+}
+
+// ctx 中使用 c.MysqlCient 开启的事务, 不在事务中或者事务属于其他数据库时返回 nil
+func (c *ModelFunc) tx(ctx context.Context) *txState {
+	if c.MysqlCient == nil {
+		return nil
+	}
+	tx, _ := ctx.Value(txKey{origin: c.MysqlCient}).(*txState)
+	if tx == nil || tx.origin != c.MysqlCient {
+		return nil
+	}
+	return tx
+}
+
+// 记录提交后需要清除的缓存
+func (t *txState) add(c *ModelFunc, keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[c] = append(t.pending[c], keys...)
+}
+
+// 提交后清除缓存, 共用同一个 RedisClient 的 ModelFunc 合并为一个 pipeline
+func (t *txState) flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make(map[redis.UniversalClient][]string)
+	owner := make(map[redis.UniversalClient]*ModelFunc)
+	for c, list := range t.pending {
+		c.removeLocal(list...)
+		keys[c.RedisClient] = append(keys[c.RedisClient], list...)
+		owner[c.RedisClient] = c
+	}
+	var errs []error
+	for client, list := range keys {
+		c := owner[client]
+		if err := c.cacheErr("Transaction", c.delKeys(ctx, list...)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 在数据库事务中执行 fn, fn 中使用传入的 ctx 调用的方法都使用同一个事务, 包括使用同一个 MysqlCient 的其他 ModelFunc
+// MysqlCient 不同的 ModelFunc 不加入该事务, 仍然直接读写数据库和缓存
+// 事务中的写入方法不直接清除缓存, 需要清除的key收集起来在提交后使用一个 pipeline 删除, 回滚时不清除
+// 事务中的查询方法不读写缓存, 防止未提交的数据写入缓存, ctx 已经在事务中时直接执行 fn
+// 设置 DefaultTimeout 时整个事务包括 fn 中的调用共用一个超时
+func (c *ModelFunc) Transaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer c.observeError("Transaction", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if err = c.Validate(); err != nil {
+		return err
+	}
+	if c.tx(ctx) != nil {
+		return fn(ctx)
+	}
+
+	state := &txState{origin: c.MysqlCient, pending: make(map[*ModelFunc][]string)}
+	err = c.db(ctx).Transaction(func(tx *gorm.DB) error {
+		state.db = tx
+		return fn(context.WithValue(ctx, txKey{origin: c.MysqlCient}, state))
+	})
+	if err != nil {
+		return err
+	}
+	return state.flush(ctx)
+}
+
+// 钩子收到的数据库链接, 在事务中时为事务的链接
+func (c *ModelFunc) hookDB(ctx context.Context) *gorm.DB {
+	if tx := c.tx(ctx); tx != nil {
+		return tx.db
+	}
+	return c.MysqlCient
+}
+
+// 使用 pipeline 批量删除缓存, keys 为完整的 redis key, 包括 RedisPrefix
+// 在 Transaction 中调用时等到提交后再删除
+func (c *ModelFunc) InvalidateKeys(ctx context.Context, keys ...string) (err error) {
+	defer c.observeError("InvalidateKeys", &err)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if nilRedis(c.RedisClient) {
+		return errors.New("InvalidateKeys 需要设置 RedisClient")
+	}
+	return c.delKeys(ctx, keys...)
+}
+
+// 记录对应的所有缓存key, 包括id缓存、link缓存和唯一字段缓存, 可以收集后传给 InvalidateKeys 一起清除
+func (c *ModelFunc) CacheKeys(ctx context.Context, model interface{}, id uint64) []string {
+	return c.invalidateKeys(ctx, model, id)
+}